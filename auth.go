@@ -0,0 +1,321 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// basicAuthTransport adds HTTP basic credentials to requests. Credentials are only given to an
+// origin after it answers with a Basic challenge, then sent up front to it. Origins are told apart
+// by scheme, so that a challenge over https never leads to sending the password over http.
+type basicAuthTransport struct {
+	next     http.RoundTripper
+	username string
+	password string
+	origins  sync.Map
+}
+
+// newBasicAuthTransport parses "user:pass" credentials
+func newBasicAuthTransport(next http.RoundTripper, credentials string) (*basicAuthTransport, error) {
+	parts := strings.SplitN(credentials, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("credentials not formatted properly (expected user:pass)")
+	}
+	return &basicAuthTransport{next: next, username: parts[0], password: parts[1]}, nil
+}
+
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	origin := req.URL.Scheme + "://" + req.URL.Host
+	if _, ok := t.origins.Load(origin); ok && req.Header.Get("Authorization") == "" {
+		req = req.Clone(req.Context())
+		req.SetBasicAuth(t.username, t.password)
+		return t.next.RoundTrip(req)
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || req.Header.Get("Authorization") != "" {
+		return resp, err
+	}
+	if !hasChallenge(resp, "Basic") {
+		return resp, nil
+	}
+	retry, err := rewindRequest(req)
+	if err != nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+	retry.SetBasicAuth(t.username, t.password)
+	t.origins.Store(origin, true)
+	return t.next.RoundTrip(retry)
+}
+
+// authorization returns the Authorization header sent up front to the origin of a URL, or ""
+// if it has not asked for Basic credentials
+func (t *basicAuthTransport) authorization(u *url.URL) string {
+	if _, ok := t.origins.Load(u.Scheme + "://" + u.Host); !ok {
+		return ""
+	}
+	req := &http.Request{Header: make(http.Header)}
+	req.SetBasicAuth(t.username, t.password)
+	return req.Header.Get("Authorization")
+}
+
+// hasChallenge reports whether a response asks for the given authentication scheme
+func hasChallenge(resp *http.Response, scheme string) bool {
+	for _, challenge := range resp.Header.Values("WWW-Authenticate") {
+		if strings.HasPrefix(strings.ToLower(challenge), strings.ToLower(scheme)) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewindRequest clones a request so it can be sent again, including its body
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, errors.New("request body cannot be replayed")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	return retry, nil
+}
+
+// digestChallenge is a parsed Digest WWW-Authenticate challenge, see RFC 7616
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	count     uint32
+}
+
+// digestAuthTransport answers Digest challenges, then keeps answering each host's last challenge up front
+type digestAuthTransport struct {
+	next       http.RoundTripper
+	username   string
+	password   string
+	mu         sync.Mutex
+	challenges map[string]*digestChallenge
+}
+
+// newDigestAuthTransport parses "user:pass" credentials
+func newDigestAuthTransport(next http.RoundTripper, credentials string) (*digestAuthTransport, error) {
+	parts := strings.SplitN(credentials, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("credentials not formatted properly (expected user:pass)")
+	}
+	return &digestAuthTransport{next: next, username: parts[0], password: parts[1], challenges: make(map[string]*digestChallenge)}, nil
+}
+
+func (t *digestAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	first := req
+	if authorization := t.authorize(req, host); authorization != "" {
+		first = req.Clone(req.Context())
+		first.Header.Set("Authorization", authorization)
+	}
+	resp, err := t.next.RoundTrip(first)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := parseDigestChallenge(resp.Header.Values("WWW-Authenticate"))
+	if challenge == nil {
+		return resp, nil
+	}
+	retry, err := rewindRequest(req)
+	if err != nil {
+		return resp, nil
+	}
+	drainBody(resp)
+	t.mu.Lock()
+	t.challenges[host] = challenge
+	t.mu.Unlock()
+	retry.Header.Set("Authorization", t.authorize(retry, host))
+	return t.next.RoundTrip(retry)
+}
+
+// authorize answers the last Digest challenge of a host for the request, if there is one
+func (t *digestAuthTransport) authorize(req *http.Request, host string) string {
+	t.mu.Lock()
+	challenge, ok := t.challenges[host]
+	if !ok {
+		t.mu.Unlock()
+		return ""
+	}
+	challenge.count++
+	count := fmt.Sprintf("%08x", challenge.count)
+	t.mu.Unlock()
+
+	h := md5Hex
+	algorithm := strings.ToUpper(challenge.algorithm)
+	if strings.HasPrefix(algorithm, "SHA-256") {
+		h = sha256Hex
+	}
+	cnonceBytes := make([]byte, 16)
+	rand.Read(cnonceBytes)
+	cnonce := hex.EncodeToString(cnonceBytes)
+
+	uri := req.URL.RequestURI()
+	ha1 := h(t.username + ":" + challenge.realm + ":" + t.password)
+	if strings.HasSuffix(algorithm, "-SESS") {
+		ha1 = h(ha1 + ":" + challenge.nonce + ":" + cnonce)
+	}
+	ha2 := h(req.Method + ":" + uri)
+	var response string
+	if challenge.qop != "" {
+		response = h(ha1 + ":" + challenge.nonce + ":" + count + ":" + cnonce + ":" + challenge.qop + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + challenge.nonce + ":" + ha2)
+	}
+
+	authorization := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`, t.username, challenge.realm, challenge.nonce, uri, response)
+	if challenge.algorithm != "" {
+		authorization += ", algorithm=" + challenge.algorithm
+	}
+	if challenge.opaque != "" {
+		authorization += fmt.Sprintf(`, opaque="%s"`, challenge.opaque)
+	}
+	if challenge.qop != "" {
+		authorization += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, challenge.qop, count, cnonce)
+	}
+	return authorization
+}
+
+// parseDigestChallenge picks the strongest Digest challenge we can answer, preferring SHA-256 to MD5
+func parseDigestChallenge(values []string) *digestChallenge {
+	var best *digestChallenge
+	for _, value := range values {
+		if !strings.HasPrefix(strings.ToLower(value), "digest ") {
+			continue
+		}
+		params := parseAuthParams(value[len("digest "):])
+		challenge := &digestChallenge{
+			realm:     params["realm"],
+			nonce:     params["nonce"],
+			opaque:    params["opaque"],
+			algorithm: params["algorithm"],
+		}
+		switch strings.ToUpper(challenge.algorithm) {
+		case "", "MD5", "MD5-SESS", "SHA-256", "SHA-256-SESS":
+		default:
+			continue
+		}
+		if qop, ok := params["qop"]; ok {
+			// only the "auth" quality of protection is supported
+			for _, option := range strings.Split(qop, ",") {
+				if strings.TrimSpace(option) == "auth" {
+					challenge.qop = "auth"
+				}
+			}
+			if challenge.qop == "" {
+				continue
+			}
+		}
+		if best == nil || strings.HasPrefix(strings.ToUpper(challenge.algorithm), "SHA-256") {
+			best = challenge
+		}
+	}
+	return best
+}
+
+// parseAuthParams parses comma-separated key=value pairs, where values may be quoted
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " ,")
+		eq := strings.Index(s, "=")
+		if eq == -1 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			// an unterminated quoted string runs to the end of the input
+			if end >= len(s) {
+				value = strings.ReplaceAll(s[1:], `\`, "")
+				s = ""
+			} else {
+				value = strings.ReplaceAll(s[1:end], `\`, "")
+				s = s[end+1:]
+			}
+		} else {
+			end := strings.Index(s, ",")
+			if end == -1 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		params[key] = value
+	}
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// negotiateTransport answers SPNEGO (Negotiate) challenges with tokens produced by an external command,
+// which is run with the service principal name as its last argument, e.g. HTTP@intranet.example.com.
+// Go has no native GSSAPI, so this lets the system's Kerberos setup (keytab or ccache) do the work.
+type negotiateTransport struct {
+	next    http.RoundTripper
+	command []string
+}
+
+func newNegotiateTransport(next http.RoundTripper, command string) (*negotiateTransport, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, errors.New("empty token command")
+	}
+	return &negotiateTransport{next: next, command: fields}, nil
+}
+
+func (t *negotiateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !hasChallenge(resp, "Negotiate") {
+		return resp, err
+	}
+	args := append(append([]string{}, t.command[1:]...), "HTTP@"+req.URL.Hostname())
+	out, err := exec.CommandContext(req.Context(), t.command[0], args...).Output()
+	if err != nil {
+		log.Println("Error running Negotiate token command:", err)
+		return resp, nil
+	}
+	retry, err := rewindRequest(req)
+	if err != nil {
+		return resp, nil
+	}
+	drainBody(resp)
+	retry.Header.Set("Authorization", "Negotiate "+strings.TrimSpace(string(out)))
+	return t.next.RoundTrip(retry)
+}
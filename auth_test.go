@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAuthParams(t *testing.T) {
+	tests := []struct {
+		input string
+		want  map[string]string
+	}{
+		{"", map[string]string{}},
+		{`realm="example"`, map[string]string{"realm": "example"}},
+		{`Realm="a b", NONCE="n", qop="auth,auth-int", algorithm=MD5`, map[string]string{"realm": "a b", "nonce": "n", "qop": "auth,auth-int", "algorithm": "MD5"}},
+		{`realm="say \"hi\"", stale=true`, map[string]string{"realm": `say "hi"`, "stale": "true"}},
+		{`realm = unquoted , opaque=""`, map[string]string{"realm": "unquoted", "opaque": ""}},
+		{"token68", map[string]string{}},
+		// unterminated quoted strings run to the end of the input
+		{`realm="`, map[string]string{"realm": ""}},
+		{`realm="abc`, map[string]string{"realm": "abc"}},
+		{`realm="abc\`, map[string]string{"realm": "abc"}},
+		{`nonce=`, map[string]string{"nonce": ""}},
+	}
+	for _, test := range tests {
+		if got := parseAuthParams(test.input); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseAuthParams(%q) = %v, want %v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestDigestAuthTransport(t *testing.T) {
+	for _, algorithm := range []string{"MD5", "SHA-256", "MD5-sess"} {
+		h := md5Hex
+		if algorithm == "SHA-256" {
+			h = sha256Hex
+		}
+		challenges := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization := r.Header.Get("Authorization")
+			if !strings.HasPrefix(authorization, "Digest ") {
+				challenges++
+				w.Header().Add("WWW-Authenticate", `Basic realm="site"`)
+				w.Header().Add("WWW-Authenticate", `Digest realm="site", nonce="dcd98b7102dd2f0e", opaque="5ccc069c", qop="auth,auth-int", algorithm=`+algorithm)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			params := parseAuthParams(authorization[len("Digest "):])
+			ha1 := h("user:site:secret")
+			if strings.HasSuffix(algorithm, "-sess") {
+				ha1 = h(ha1 + ":dcd98b7102dd2f0e:" + params["cnonce"])
+			}
+			ha2 := h(r.Method + ":" + r.URL.RequestURI())
+			response := h(ha1 + ":dcd98b7102dd2f0e:" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+			if params["username"] != "user" || params["uri"] != r.URL.RequestURI() || params["opaque"] != "5ccc069c" ||
+				params["qop"] != "auth" || params["response"] != response {
+				t.Errorf("%s: bad Authorization header %q", algorithm, authorization)
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		transport, err := newDigestAuthTransport(http.DefaultTransport, "user:secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{Transport: transport}
+		for _, path := range []string{"/", "/dir/index.html?a=1"} {
+			resp, err := client.Get(server.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("%s: GET %s = %d, want 200", algorithm, path, resp.StatusCode)
+			}
+		}
+		// once challenged, the host is answered up front
+		if challenges != 1 {
+			t.Errorf("%s: %d challenges, want 1", algorithm, challenges)
+		}
+		server.Close()
+	}
+}
+
+func TestBasicAuthTransport(t *testing.T) {
+	// the paths requested with credentials
+	var credentialed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if ok {
+			credentialed = append(credentialed, r.URL.Path)
+			if username != "user" || password != "secret" {
+				t.Errorf("got credentials %q:%q", username, password)
+			}
+		}
+		switch {
+		case r.URL.Path == "/bearer":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case !ok:
+			w.Header().Set("WWW-Authenticate", `Basic realm="site"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	transport, err := newBasicAuthTransport(http.DefaultTransport, "user:secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+	get := func(path string) int {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// credentials are not given without a Basic challenge
+	if status := get("/bearer"); status != http.StatusUnauthorized {
+		t.Errorf("GET /bearer = %d, want 401", status)
+	}
+	if status := get("/"); status != http.StatusOK {
+		t.Errorf("GET / = %d, want 200", status)
+	}
+	// once challenged, the origin is given them up front
+	get("/bearer")
+	if want := []string{"/", "/bearer"}; !reflect.DeepEqual(credentialed, want) {
+		t.Errorf("credentials sent for %v, want %v", credentialed, want)
+	}
+
+	// and the credentials can be handed on for it
+	u, _ := url.Parse(server.URL + "/page")
+	if authorization := transport.authorization(u); authorization != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("authorization(%s) = %q, want the Basic credentials", u, authorization)
+	}
+	u.Scheme = "https"
+	if authorization := transport.authorization(u); authorization != "" {
+		t.Errorf("authorization(%s) = %q, want none for another origin", u, authorization)
+	}
+
+	if _, err := newBasicAuthTransport(http.DefaultTransport, "user"); err == nil {
+		t.Error("newBasicAuthTransport accepted credentials without a password")
+	}
+}
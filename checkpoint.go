@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// crawlCheckpoint keeps track of the URLs visited and pending, and saves them to a file
+// periodically, for -checkpoint. A saved checkpoint can be resumed with -resume.
+type crawlCheckpoint struct {
+	filename string
+	mu       sync.Mutex
+	visited  map[string]bool
+	pending  map[string]bool
+}
+
+// checkpointState is the content of a checkpoint file
+type checkpointState struct {
+	Visited []string `json:"visited"`
+	Pending []string `json:"pending"`
+}
+
+func newCrawlCheckpoint(filename string) *crawlCheckpoint {
+	return &crawlCheckpoint{filename: filename, visited: make(map[string]bool), pending: make(map[string]bool)}
+}
+
+// loadCheckpoint reads a checkpoint file saved by an earlier crawl
+func loadCheckpoint(filename string) (checkpointState, error) {
+	var state checkpointState
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// restore carries the URLs visited by an earlier crawl over, so they are saved again
+func (cp *crawlCheckpoint) restore(state checkpointState) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	for _, u := range state.Visited {
+		cp.visited[u] = true
+	}
+}
+
+// track registers the callbacks keeping track of a collector's GET requests
+func (cp *crawlCheckpoint) track(c *colly.Collector) {
+	c.OnRequest(func(r *colly.Request) {
+		if r.Method != "GET" {
+			return
+		}
+		cp.mu.Lock()
+		cp.pending[r.URL.String()] = true
+		cp.mu.Unlock()
+	})
+	done := func(r *colly.Request) {
+		cp.mu.Lock()
+		delete(cp.pending, r.URL.String())
+		cp.visited[r.URL.String()] = true
+		cp.mu.Unlock()
+	}
+	c.OnResponse(func(r *colly.Response) { done(r.Request) })
+	c.OnError(func(r *colly.Response, err error) { done(r.Request) })
+}
+
+// save writes the checkpoint, replacing the previous one only once it is complete
+func (cp *crawlCheckpoint) save() error {
+	var state checkpointState
+	cp.mu.Lock()
+	for u := range cp.visited {
+		state.Visited = append(state.Visited, u)
+	}
+	for u := range cp.pending {
+		state.Pending = append(state.Pending, u)
+	}
+	cp.mu.Unlock()
+	sort.Strings(state.Visited)
+	sort.Strings(state.Pending)
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := cp.filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.filename)
+}
+
+// run saves the checkpoint every interval
+func (cp *crawlCheckpoint) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := cp.save(); err != nil {
+			log.Println("Error saving checkpoint:", err)
+		}
+	}
+}
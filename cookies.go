@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jarCookie is a cookie along with the URL it should be stored for in the cookie jar
+type jarCookie struct {
+	url    string
+	cookie *http.Cookie
+}
+
+// parseCookiesFile reads cookies from a Netscape cookies.txt file, as exported by curl and browser extensions
+func parseCookiesFile(filename string) ([]jarCookie, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cookies []jarCookie
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		httpOnly := strings.HasPrefix(text, "#HttpOnly_")
+		text = strings.TrimPrefix(text, "#HttpOnly_")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab-separated fields, got %d", line, len(fields))
+		}
+		domain, includeSubdomains, path, secure, expires, name, value := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6]
+
+		cookie := &http.Cookie{
+			Name:     name,
+			Value:    value,
+			Path:     path,
+			Secure:   strings.EqualFold(secure, "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if strings.EqualFold(includeSubdomains, "TRUE") {
+			cookie.Domain = domain
+		}
+		if seconds, err := strconv.ParseInt(expires, 10, 64); err == nil && seconds > 0 {
+			cookie.Expires = time.Unix(seconds, 0)
+		}
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		cookies = append(cookies, jarCookie{url: scheme + "://" + strings.TrimPrefix(domain, ".") + path, cookie: cookie})
+	}
+	return cookies, s.Err()
+}
+
+// browserCookies reads the cookies for hostname and its parent domains from the most recently used
+// profile of a local browser. The sqlite3 command line tool is used to read the cookie database.
+// Chrome cookies are decrypted where the key is available without user interaction (Linux "v10"
+// cookies and the macOS keychain); others are skipped.
+func browserCookies(browser string, hostname string) ([]jarCookie, error) {
+	var patterns []string
+	var query string
+	home, _ := os.UserHomeDir()
+	switch browser {
+	case "firefox":
+		patterns = []string{
+			filepath.Join(home, ".mozilla", "firefox", "*", "cookies.sqlite"),
+			filepath.Join(home, "Library", "Application Support", "Firefox", "Profiles", "*", "cookies.sqlite"),
+			filepath.Join(os.Getenv("APPDATA"), "Mozilla", "Firefox", "Profiles", "*", "cookies.sqlite"),
+		}
+		query = "SELECT host, path, isSecure, expiry, name, hex(value), isHttpOnly FROM moz_cookies"
+	case "chrome":
+		patterns = []string{
+			filepath.Join(home, ".config", "google-chrome", "*", "Cookies"),
+			filepath.Join(home, ".config", "google-chrome", "*", "Network", "Cookies"),
+			filepath.Join(home, "Library", "Application Support", "Google", "Chrome", "*", "Cookies"),
+			filepath.Join(os.Getenv("LOCALAPPDATA"), "Google", "Chrome", "User Data", "*", "Network", "Cookies"),
+		}
+		query = "SELECT host_key, path, is_secure, expires_utc, name, hex(value) || '.' || hex(encrypted_value), is_httponly FROM cookies"
+	default:
+		return nil, fmt.Errorf("unsupported browser %q (expected chrome or firefox)", browser)
+	}
+
+	// use the most recently modified cookie database
+	var database string
+	var newest time.Time
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.ModTime().After(newest) {
+				database, newest = match, info.ModTime()
+			}
+		}
+	}
+	if database == "" {
+		return nil, fmt.Errorf("no %s cookie database found", browser)
+	}
+
+	// the browser keeps the database locked while running, so read a copy of it
+	data, err := os.ReadFile(database)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp("", "hakrawler-cookies-*.sqlite")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err != nil {
+		return nil, err
+	}
+	out, err := exec.Command("sqlite3", "-readonly", "-separator", "\x1f", tmp.Name(), query).Output()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s with sqlite3: %v", database, err)
+	}
+
+	var key []byte
+	if browser == "chrome" {
+		key = chromeKey()
+	}
+	var cookies []jarCookie
+	for _, row := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(row, "\x1f")
+		if len(fields) != 7 {
+			continue
+		}
+		host := strings.TrimPrefix(fields[0], ".")
+		if hostname != host && !strings.HasSuffix(hostname, "."+host) {
+			continue
+		}
+		var value string
+		if browser == "chrome" {
+			parts := strings.SplitN(fields[5], ".", 2)
+			plain, _ := hex.DecodeString(parts[0])
+			encrypted, _ := hex.DecodeString(parts[1])
+			value = string(plain)
+			if len(encrypted) > 0 {
+				value, err = decryptChromeValue(encrypted, key, fields[0])
+				if err != nil {
+					continue
+				}
+			}
+		} else {
+			plain, _ := hex.DecodeString(fields[5])
+			value = string(plain)
+		}
+
+		cookie := &http.Cookie{
+			Name:     fields[4],
+			Value:    value,
+			Path:     fields[1],
+			Secure:   fields[2] == "1",
+			HttpOnly: fields[6] == "1",
+		}
+		if strings.HasPrefix(fields[0], ".") {
+			cookie.Domain = fields[0]
+		}
+		if expires, err := strconv.ParseInt(fields[3], 10, 64); err == nil && expires > 0 {
+			if browser == "chrome" {
+				// microseconds since 1601-01-01
+				expires = expires/1000000 - 11644473600
+			}
+			cookie.Expires = time.Unix(expires, 0)
+		}
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		cookies = append(cookies, jarCookie{url: scheme + "://" + host + cookie.Path, cookie: cookie})
+	}
+	return cookies, nil
+}
+
+// chromeKey derives the AES key Chrome encrypts cookie values with. On Linux without a keyring
+// Chrome uses a fixed password, on macOS the password lives in the keychain.
+func chromeKey() []byte {
+	password, iterations := []byte("peanuts"), 1
+	if runtime.GOOS == "darwin" {
+		out, err := exec.Command("security", "find-generic-password", "-wa", "Chrome").Output()
+		if err != nil {
+			return nil
+		}
+		password, iterations = bytes.TrimSpace(out), 1003
+	}
+	return pbkdf2SHA1(password, []byte("saltysalt"), iterations, 16)
+}
+
+// decryptChromeValue decrypts a "v10" Chrome cookie value with AES-128-CBC
+func decryptChromeValue(encrypted []byte, key []byte, hostKey string) (string, error) {
+	if key == nil || !bytes.HasPrefix(encrypted, []byte("v10")) {
+		return "", errors.New("unsupported cookie encryption")
+	}
+	encrypted = encrypted[3:]
+	if len(encrypted) == 0 || len(encrypted)%aes.BlockSize != 0 {
+		return "", errors.New("invalid encrypted cookie length")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	plain := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(block, bytes.Repeat([]byte(" "), aes.BlockSize)).CryptBlocks(plain, encrypted)
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return "", errors.New("invalid cookie padding")
+	}
+	plain = plain[:len(plain)-padding]
+	// recent versions prefix the value with a hash of the cookie's domain
+	hostHash := sha256.Sum256([]byte(hostKey))
+	plain = bytes.TrimPrefix(plain, hostHash[:])
+	return string(plain), nil
+}
+
+// pbkdf2SHA1 implements RFC 8018 key derivation with HMAC-SHA1
+func pbkdf2SHA1(password, salt []byte, iterations, keyLen int) []byte {
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		mac := hmac.New(sha1.New, password)
+		mac.Write(salt)
+		mac.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := mac.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			mac = hmac.New(sha1.New, password)
+			mac.Write(u)
+			u = mac.Sum(nil)
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// devtools is a Chrome process driven over the DevTools protocol, through the pipe opened by
+// --remote-debugging-pipe: Chrome reads commands from its fd 3 and writes replies and events
+// to its fd 4, as JSON messages each ended by a NUL byte. Pipes cannot be passed that way on
+// Windows.
+type devtools struct {
+	cmd    *exec.Cmd
+	ctx    context.Context
+	writer *os.File
+	mu     sync.Mutex
+	nextID int
+	// replies are where the replies to the commands sent are expected, by command ID
+	replies map[int]chan devtoolsMessage
+	// events is called with every event, in order, from the goroutine reading them
+	events func(devtoolsMessage)
+	// done is closed once Chrome closed the pipe
+	done chan struct{}
+}
+
+// devtoolsMessage is a reply or an event from Chrome
+type devtoolsMessage struct {
+	ID        int             `json:"id"`
+	SessionID string          `json:"sessionId"`
+	Method    string          `json:"method"`
+	Params    json.RawMessage `json:"params"`
+	Result    json.RawMessage `json:"result"`
+	Error     *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// startDevtools starts Chrome with a DevTools pipe. The process is killed when ctx is done.
+func startDevtools(ctx context.Context, binary string, args []string, events func(devtoolsMessage)) (*devtools, error) {
+	// Chrome reads from commands and writes to replies
+	commands, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	reader, replies, err := os.Pipe()
+	if err != nil {
+		commands.Close()
+		writer.Close()
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, binary, append(append([]string{}, args...), "--remote-debugging-pipe", "about:blank")...)
+	cmd.ExtraFiles = []*os.File{commands, replies}
+	err = cmd.Start()
+	commands.Close()
+	replies.Close()
+	if err != nil {
+		writer.Close()
+		reader.Close()
+		return nil, err
+	}
+
+	d := &devtools{
+		cmd:     cmd,
+		ctx:     ctx,
+		writer:  writer,
+		replies: make(map[int]chan devtoolsMessage),
+		events:  events,
+		done:    make(chan struct{}),
+	}
+	go d.read(reader)
+	return d, nil
+}
+
+// read hands the messages from Chrome to the commands waiting for them and to events
+func (d *devtools) read(reader *os.File) {
+	defer close(d.done)
+	defer reader.Close()
+	buffered := bufio.NewReader(reader)
+	for {
+		data, err := buffered.ReadBytes(0)
+		if err != nil {
+			return
+		}
+		var message devtoolsMessage
+		if json.Unmarshal(data[:len(data)-1], &message) != nil {
+			continue
+		}
+		if message.Method != "" {
+			if d.events != nil {
+				d.events(message)
+			}
+			continue
+		}
+		d.mu.Lock()
+		reply, ok := d.replies[message.ID]
+		delete(d.replies, message.ID)
+		d.mu.Unlock()
+		if ok {
+			reply <- message
+		}
+	}
+}
+
+// call sends a command, to the browser or to the page a session is attached to, and decodes
+// its result into result, unless it is nil
+func (d *devtools) call(sessionID string, method string, params interface{}, result interface{}) error {
+	if params == nil {
+		params = struct{}{}
+	}
+	reply := make(chan devtoolsMessage, 1)
+	d.mu.Lock()
+	d.nextID++
+	id := d.nextID
+	d.replies[id] = reply
+	data, err := json.Marshal(struct {
+		ID        int         `json:"id"`
+		SessionID string      `json:"sessionId,omitempty"`
+		Method    string      `json:"method"`
+		Params    interface{} `json:"params"`
+	}{id, sessionID, method, params})
+	if err == nil {
+		_, err = d.writer.Write(append(data, 0))
+	}
+	if err != nil {
+		delete(d.replies, id)
+		d.mu.Unlock()
+		return err
+	}
+	d.mu.Unlock()
+
+	select {
+	case message := <-reply:
+		if message.Error != nil {
+			return errors.New(method + ": " + message.Error.Message)
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(message.Result, result)
+	case <-d.done:
+		return errors.New("Chrome exited")
+	case <-d.ctx.Done():
+		return d.ctx.Err()
+	}
+}
+
+// close asks Chrome to exit, and kills it if it has not within a second
+func (d *devtools) close() {
+	go d.call("", "Browser.close", nil, nil)
+	select {
+	case <-d.done:
+	case <-time.After(time.Second):
+	}
+	d.writer.Close()
+	d.cmd.Process.Kill()
+	d.cmd.Wait()
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	_ "net/http/pprof"
+	"net/url"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// hostCounters counts the requests of a host, and the results found on it by source
+type hostCounters struct {
+	Requests  int64
+	Responses int64
+	Errors    int64
+	// ErrorStatuses counts the responses with a 4xx or 5xx status
+	ErrorStatuses int64
+	Results       map[string]int64
+	// latencySum is the total time taken by its responses, in seconds
+	latencySum float64
+}
+
+// crawlStats counts what the crawl is doing, for the -pprof status page, -metrics and -summary
+type crawlStats struct {
+	// inFlight and queued come first to be 64-bit aligned for atomic operations
+	inFlight int64
+	// queued counts the requests waiting to be sent, spilled by the frontier or held by colly
+	queued   int64
+	started  time.Time
+	mu       sync.Mutex
+	hosts    map[string]*hostCounters
+	statuses map[int]int64
+	// latencies counts the responses taking up to each of latencyBuckets, in seconds
+	latencies    []int64
+	latencySum   float64
+	latencyCount int64
+	// favicons are the -favicon hashes of hosts
+	favicons map[string]int32
+}
+
+// latencyBuckets are the upper bounds of the response time histogram, in seconds
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Crawl counters, or nil when nothing reports them
+var stats *crawlStats
+
+func newCrawlStats() *crawlStats {
+	return &crawlStats{
+		started:   time.Now(),
+		hosts:     make(map[string]*hostCounters),
+		statuses:  make(map[int]int64),
+		latencies: make([]int64, len(latencyBuckets)),
+		favicons:  make(map[string]int32),
+	}
+}
+
+// host returns the counters of a host, creating them on first use. Callers hold s.mu.
+func (s *crawlStats) host(hostname string) *hostCounters {
+	counters, ok := s.hosts[hostname]
+	if !ok {
+		counters = &hostCounters{Results: make(map[string]int64)}
+		s.hosts[hostname] = counters
+	}
+	return counters
+}
+
+// queueHeader marks the requests counted as queued, until they are sent. It is taken off before.
+const queueHeader = "X-Hakrawler-Queued"
+
+// abortedHeader marks the requests aborted by an OnRequest callback. They are never sent, so it
+// never goes out.
+const abortedHeader = "X-Hakrawler-Aborted"
+
+// abortRequest aborts a request from an OnRequest callback. colly does not tell whether a request
+// was aborted, so callbacks abort through it for track to leave the request out of the queue.
+func abortRequest(r *colly.Request) {
+	r.Headers.Set(abortedHeader, "1")
+	r.Abort()
+}
+
+// track counts the requests of a collector as queued until they are sent. It is to be called
+// after every other callback is registered, to leave out the requests they abort.
+func (s *crawlStats) track(c *colly.Collector) {
+	c.OnRequest(func(r *colly.Request) {
+		if r.Headers.Get(abortedHeader) != "" {
+			return
+		}
+		atomic.AddInt64(&s.queued, 1)
+		r.Headers.Set(queueHeader, "1")
+	})
+}
+
+// dequeue returns a RoundTripper counting the queued requests out as they are sent through
+// next. It is to wrap every other RoundTripper, so that none of them sees the queueHeader.
+func (s *crawlStats) dequeue(next http.RoundTripper) http.RoundTripper {
+	return &dequeueTransport{next: next, stats: s}
+}
+
+// dequeueTransport is a RoundTripper taking the queueHeader off requests
+type dequeueTransport struct {
+	next  http.RoundTripper
+	stats *crawlStats
+}
+
+func (t *dequeueTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get(queueHeader) == "" {
+		return t.next.RoundTrip(req)
+	}
+	// redirects carry the headers of the first request, which was already counted out
+	if req.Response == nil {
+		atomic.AddInt64(&t.stats.queued, -1)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Del(queueHeader)
+	return t.next.RoundTrip(req)
+}
+
+// wrap returns a RoundTripper counting the requests sent through next
+func (s *crawlStats) wrap(next http.RoundTripper) http.RoundTripper {
+	return &countingTransport{next: next, stats: s}
+}
+
+// countingTransport is a RoundTripper updating crawlStats
+type countingTransport struct {
+	next  http.RoundTripper
+	stats *crawlStats
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s := t.stats
+	atomic.AddInt64(&s.inFlight, 1)
+	s.mu.Lock()
+	s.host(req.URL.Hostname()).Requests++
+	s.mu.Unlock()
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Seconds()
+
+	atomic.AddInt64(&s.inFlight, -1)
+	s.mu.Lock()
+	if err != nil {
+		s.host(req.URL.Hostname()).Errors++
+	} else {
+		counters := s.host(req.URL.Hostname())
+		counters.Responses++
+		counters.latencySum += elapsed
+		if resp.StatusCode >= 400 {
+			counters.ErrorStatuses++
+		}
+		s.statuses[resp.StatusCode]++
+		for i, bound := range latencyBuckets {
+			if elapsed <= bound {
+				s.latencies[i]++
+			}
+		}
+		s.latencySum += elapsed
+		s.latencyCount++
+	}
+	s.mu.Unlock()
+	return resp, err
+}
+
+// result counts a result output under the host of its URL
+func (s *crawlStats) result(res Result) {
+	u, err := url.Parse(res.URL)
+	if err != nil || u.Host == "" {
+		return
+	}
+	s.mu.Lock()
+	s.host(u.Hostname()).Results[res.Source]++
+	s.mu.Unlock()
+}
+
+// favicon records the favicon hash of a host
+func (s *crawlStats) favicon(hostname string, hash int32) {
+	s.mu.Lock()
+	s.favicons[hostname] = hash
+	s.mu.Unlock()
+}
+
+// hostSummary is the breakdown of a host in the -summary file
+type hostSummary struct {
+	Host          string
+	Pages         int64
+	Requests      int64
+	Errors        int64
+	ErrorStatuses int64
+	// ErrorRate is the share of requests that failed or got a 4xx or 5xx status
+	ErrorRate float64
+	// AverageLatency is the average time to receive response headers, in milliseconds
+	AverageLatency float64
+	Results        map[string]int64
+	// FaviconHash is the mmh3 hash of the host's favicon with -favicon, as searched by Shodan and FOFA
+	FaviconHash *int32 `json:",omitempty"`
+}
+
+// writeSummary writes the totals of the crawl and the breakdown of every host to filename, as JSON
+func (s *crawlStats) writeSummary(filename string) error {
+	s.mu.Lock()
+	summary := struct {
+		Duration string
+		Results  int64
+		Statuses map[int]int64
+		Hosts    []hostSummary
+	}{
+		Duration: time.Since(s.started).Round(time.Second).String(),
+		Results:  atomic.LoadInt64(&resultsSent),
+		Statuses: s.statuses,
+	}
+	for hostname, counters := range s.hosts {
+		host := hostSummary{
+			Host:          hostname,
+			Pages:         counters.Responses,
+			Requests:      counters.Requests,
+			Errors:        counters.Errors,
+			ErrorStatuses: counters.ErrorStatuses,
+			Results:       counters.Results,
+		}
+		if counters.Requests > 0 {
+			host.ErrorRate = float64(counters.Errors+counters.ErrorStatuses) / float64(counters.Requests)
+		}
+		if hash, ok := s.favicons[hostname]; ok {
+			host.FaviconHash = &hash
+		}
+		if counters.Responses > 0 {
+			host.AverageLatency = milliseconds(time.Duration(counters.latencySum / float64(counters.Responses) * float64(time.Second)))
+		}
+		summary.Hosts = append(summary.Hosts, host)
+	}
+	sort.Slice(summary.Hosts, func(i, j int) bool { return summary.Hosts[i].Host < summary.Hosts[j].Host })
+	data, err := json.MarshalIndent(summary, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// serveStatus writes the state of the crawl as JSON
+func (s *crawlStats) serveStatus(w http.ResponseWriter, req *http.Request) {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	status := struct {
+		Uptime     string
+		Goroutines int
+		HeapBytes  uint64
+		InFlight   int64
+		Queued     int64
+		Results    int64
+		Hosts      map[string]hostCounters
+	}{
+		Uptime:     time.Since(s.started).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		HeapBytes:  memory.HeapAlloc,
+		InFlight:   atomic.LoadInt64(&s.inFlight),
+		Queued:     atomic.LoadInt64(&s.queued),
+		Results:    atomic.LoadInt64(&resultsSent),
+		Hosts:      make(map[string]hostCounters),
+	}
+	s.mu.Lock()
+	for hostname, counters := range s.hosts {
+		copied := *counters
+		copied.Results = make(map[string]int64)
+		for source, count := range counters.Results {
+			copied.Results[source] = count
+		}
+		status.Hosts[hostname] = copied
+	}
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(status)
+}
+
+// serveDiagnostics serves the pprof profiles under /debug/pprof/ and the crawl status under
+// /status on addr
+func serveDiagnostics(addr string) {
+	http.HandleFunc("/status", stats.serveStatus)
+	if err := http.ListenAndServe(addr, nil); err != nil {
+		log.Println("Error serving diagnostics:", err)
+	}
+}
+
+// serveMetrics serves the crawl counters in the Prometheus text format under /metrics on addr
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", stats.serveMetrics)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Println("Error serving metrics:", err)
+	}
+}
+
+// serveMetrics writes the crawl counters in the Prometheus text format
+func (s *crawlStats) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hostnames := make([]string, 0, len(s.hosts))
+	for hostname := range s.hosts {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+	for _, metric := range []struct {
+		name, help string
+		value      func(*hostCounters) int64
+	}{
+		{"hakrawler_requests_total", "Requests sent, by host.", func(c *hostCounters) int64 { return c.Requests }},
+		{"hakrawler_responses_total", "Responses received, by host.", func(c *hostCounters) int64 { return c.Responses }},
+		{"hakrawler_errors_total", "Requests that failed without a response, by host.", func(c *hostCounters) int64 { return c.Errors }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", metric.name, metric.help, metric.name)
+		for _, hostname := range hostnames {
+			fmt.Fprintf(w, "%s{host=%q} %d\n", metric.name, hostname, metric.value(s.hosts[hostname]))
+		}
+	}
+
+	codes := make([]int, 0, len(s.statuses))
+	for code := range s.statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	fmt.Fprintf(w, "# HELP hakrawler_responses_by_code_total Responses received, by status code.\n# TYPE hakrawler_responses_by_code_total counter\n")
+	for _, code := range codes {
+		fmt.Fprintf(w, "hakrawler_responses_by_code_total{code=\"%d\"} %d\n", code, s.statuses[code])
+	}
+
+	fmt.Fprintf(w, "# HELP hakrawler_response_seconds Time to receive response headers.\n# TYPE hakrawler_response_seconds histogram\n")
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(w, "hakrawler_response_seconds_bucket{le=\"%g\"} %d\n", bound, s.latencies[i])
+	}
+	fmt.Fprintf(w, "hakrawler_response_seconds_bucket{le=\"+Inf\"} %d\n", s.latencyCount)
+	fmt.Fprintf(w, "hakrawler_response_seconds_sum %g\nhakrawler_response_seconds_count %d\n", s.latencySum, s.latencyCount)
+
+	fmt.Fprintf(w, "# HELP hakrawler_in_flight_requests Requests sent and waiting for a response.\n# TYPE hakrawler_in_flight_requests gauge\n")
+	fmt.Fprintf(w, "hakrawler_in_flight_requests %d\n", atomic.LoadInt64(&s.inFlight))
+	fmt.Fprintf(w, "# HELP hakrawler_queue_size Requests queued and waiting to be sent, including those spilled to disk.\n# TYPE hakrawler_queue_size gauge\n")
+	fmt.Fprintf(w, "hakrawler_queue_size %d\n", atomic.LoadInt64(&s.queued))
+	fmt.Fprintf(w, "# HELP hakrawler_results_total Results output.\n# TYPE hakrawler_results_total counter\n")
+	fmt.Fprintf(w, "hakrawler_results_total %d\n", atomic.LoadInt64(&resultsSent))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDequeueTransportRedirects(t *testing.T) {
+	var leaked bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(queueHeader) != "" {
+			leaked = true
+		}
+		switch r.URL.Path {
+		case "/":
+			http.Redirect(w, r, "/one", http.StatusFound)
+		case "/one":
+			http.Redirect(w, r, "/two", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	s := newCrawlStats()
+	s.queued = 1
+	client := &http.Client{Transport: s.dequeue(http.DefaultTransport)}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set(queueHeader, "1")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if s.queued != 0 {
+		t.Errorf("queued = %d after two redirects, want 0", s.queued)
+	}
+	if leaked {
+		t.Errorf("%s was sent", queueHeader)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dohResolver resolves hostnames with DNS over HTTPS (RFC 8484), for -doh. The DoH server
+// itself is reached through the system resolver, unless it is given by address.
+type dohResolver struct {
+	endpoint string
+	client   *http.Client
+	mu       sync.Mutex
+	cache    map[string]dohAnswer
+}
+
+// dohAnswer holds the addresses of a hostname until the smallest TTL of its records is over
+type dohAnswer struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// defaultDialer dials connections when nothing else does, with the settings of http.DefaultTransport
+var defaultDialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+// DNS record types
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+)
+
+func newDoHResolver(endpoint string) *dohResolver {
+	return &dohResolver{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		cache:    make(map[string]dohAnswer),
+	}
+}
+
+// dialContext connects like net.Dialer does, resolving the hostname with DoH
+func (d *dohResolver) dialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return defaultDialer.DialContext(ctx, network, address)
+	}
+	ips, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = defaultDialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// lookup returns the IPv4 then IPv6 addresses of a hostname
+func (d *dohResolver) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	d.mu.Lock()
+	answer, ok := d.cache[host]
+	d.mu.Unlock()
+	if ok && time.Now().Before(answer.expires) {
+		return answer.ips, nil
+	}
+
+	answer = dohAnswer{}
+	var ttl uint32
+	var lastErr error
+	for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		ips, recordTTL, err := d.query(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(ips) > 0 && (answer.ips == nil || recordTTL < ttl) {
+			ttl = recordTTL
+		}
+		answer.ips = append(answer.ips, ips...)
+	}
+	if answer.ips == nil {
+		if lastErr == nil {
+			lastErr = errors.New("no such host")
+		}
+		return nil, fmt.Errorf("DoH lookup of %s: %v", host, lastErr)
+	}
+	answer.expires = time.Now().Add(time.Duration(ttl) * time.Second)
+	d.mu.Lock()
+	d.cache[host] = answer
+	d.mu.Unlock()
+	return answer.ips, nil
+}
+
+// query sends one DNS question to the DoH server, and returns the addresses answered along
+// with their smallest TTL
+func (d *dohResolver) query(ctx context.Context, host string, qtype uint16) ([]net.IP, uint32, error) {
+	msg, err := dnsQuestion(host, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", d.endpoint, bytes.NewReader(msg))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("DoH server returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, 0, err
+	}
+	return dnsAnswers(body, qtype)
+}
+
+// dnsQuestion encodes a recursive query for the records of a type of a hostname
+func dnsQuestion(host string, qtype uint16) ([]byte, error) {
+	// ID 0 as RFC 8484 recommends, recursion desired, one question
+	msg := []byte{0, 0, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("invalid hostname %q", host)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, byte(qtype>>8), byte(qtype), 0, 1)
+	return msg, nil
+}
+
+// dnsAnswers decodes the addresses of a type in a DNS response, and their smallest TTL
+func dnsAnswers(msg []byte, qtype uint16) ([]net.IP, uint32, error) {
+	if len(msg) < 12 {
+		return nil, 0, errors.New("short DNS response")
+	}
+	if rcode := msg[3] & 0x0f; rcode != 0 {
+		if rcode == 3 {
+			return nil, 0, errors.New("no such host")
+		}
+		return nil, 0, fmt.Errorf("DNS error code %d", rcode)
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:6]))
+	answers := int(binary.BigEndian.Uint16(msg[6:8]))
+	off := 12
+	var err error
+	for i := 0; i < questions; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		off += 4
+	}
+
+	var ips []net.IP
+	var ttl uint32
+	for i := 0; i < answers; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		if off+10 > len(msg) {
+			return nil, 0, errors.New("short DNS response")
+		}
+		rtype := binary.BigEndian.Uint16(msg[off : off+2])
+		recordTTL := binary.BigEndian.Uint32(msg[off+4 : off+8])
+		length := int(binary.BigEndian.Uint16(msg[off+8 : off+10]))
+		off += 10
+		if off+length > len(msg) {
+			return nil, 0, errors.New("short DNS response")
+		}
+		// CNAME records come first and are followed by the addresses they lead to
+		if rtype == qtype && (length == net.IPv4len || length == net.IPv6len) {
+			ips = append(ips, net.IP(append([]byte{}, msg[off:off+length]...)))
+			if len(ips) == 1 || recordTTL < ttl {
+				ttl = recordTTL
+			}
+		}
+		off += length
+	}
+	return ips, ttl, nil
+}
+
+// skipDNSName returns the offset following the, possibly compressed, name at off
+func skipDNSName(msg []byte, off int) (int, error) {
+	for off < len(msg) {
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			return off + 1, nil
+		case length&0xc0 == 0xc0:
+			return off + 2, nil
+		}
+		off += 1 + length
+	}
+	return 0, errors.New("short DNS response")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+// dnsTestResponse is the answer to an A query for example.com: a CNAME to www.example.com,
+// then two addresses for it. Names after the question are compressed.
+var dnsTestResponse = []byte{
+	0, 0, 0x81, 0x80, 0, 1, 0, 3, 0, 0, 0, 0,
+	// question: example.com A IN, at offset 12
+	7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 1, 0, 1,
+	// example.com CNAME www.example.com, TTL 300
+	0xc0, 12, 0, 5, 0, 1, 0, 0, 1, 0x2c, 0, 6, 3, 'w', 'w', 'w', 0xc0, 12,
+	// www.example.com A 93.184.216.34, TTL 60
+	0xc0, 41, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 93, 184, 216, 34,
+	// www.example.com A 93.184.216.35, TTL 120
+	0xc0, 41, 0, 1, 0, 1, 0, 0, 0, 120, 0, 4, 93, 184, 216, 35,
+}
+
+func TestDNSAnswers(t *testing.T) {
+	nxdomain := append([]byte{}, dnsTestResponse[:12]...)
+	nxdomain[3] = 0x83
+	servfail := append([]byte{}, dnsTestResponse[:12]...)
+	servfail[3] = 0x82
+
+	tests := []struct {
+		name  string
+		msg   []byte
+		qtype uint16
+		ips   []net.IP
+		ttl   uint32
+		err   bool
+	}{
+		{"A records after a CNAME", dnsTestResponse, 1, []net.IP{net.IPv4(93, 184, 216, 34).To4(), net.IPv4(93, 184, 216, 35).To4()}, 60, false},
+		{"no AAAA records", dnsTestResponse, 28, nil, 0, false},
+		{"NXDOMAIN", nxdomain, 1, nil, 0, true},
+		{"SERVFAIL", servfail, 1, nil, 0, true},
+		{"short header", dnsTestResponse[:11], 1, nil, 0, true},
+		{"truncated answer", dnsTestResponse[:len(dnsTestResponse)-2], 1, nil, 0, true},
+		{"truncated question", dnsTestResponse[:20], 1, nil, 0, true},
+	}
+	for _, test := range tests {
+		ips, ttl, err := dnsAnswers(test.msg, test.qtype)
+		if (err != nil) != test.err {
+			t.Errorf("%s: error %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(ips, test.ips) || ttl != test.ttl {
+			t.Errorf("%s: got %v with TTL %d, want %v with TTL %d", test.name, ips, ttl, test.ips, test.ttl)
+		}
+	}
+}
+
+func TestSkipDNSName(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		off  int
+		next int
+		err  bool
+	}{
+		{"root", []byte{0}, 0, 1, false},
+		{"labels", []byte{3, 'w', 'w', 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0, 0xff}, 0, 13, false},
+		{"pointer", []byte{0xc0, 12, 0xff}, 0, 2, false},
+		{"labels then a pointer", dnsTestResponse, 41, 47, false},
+		{"unterminated", []byte{3, 'w', 'w', 'w'}, 0, 0, true},
+		{"label past the end", []byte{9, 'a'}, 0, 0, true},
+		{"offset past the end", []byte{0}, 1, 0, true},
+	}
+	for _, test := range tests {
+		next, err := skipDNSName(test.msg, test.off)
+		if (err != nil) != test.err || next != test.next {
+			t.Errorf("%s: skipDNSName = %d, %v, want %d", test.name, next, err, test.next)
+		}
+	}
+}
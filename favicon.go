@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// maxFaviconSize is the largest favicon hashed, bigger responses are unlikely to be icons
+const maxFaviconSize = 1024 * 1024
+
+// isFavicon returns whether a URL is the /favicon.ico of its origin
+func isFavicon(u *url.URL) bool {
+	return u.Path == "/favicon.ico" && u.RawQuery == ""
+}
+
+// faviconBody returns the body of a response to a /favicon.ico request, or false if it is not an icon
+func faviconBody(r *colly.Response) ([]byte, bool) {
+	// sites routing every path to their app answer with a page rather than a 404
+	if r.StatusCode != http.StatusOK || len(r.Body) == 0 || len(r.Body) > maxFaviconSize || strings.HasPrefix(r.Headers.Get("Content-Type"), "text/html") {
+		return nil, false
+	}
+	return r.Body, true
+}
+
+// faviconHash computes the favicon hash Shodan (http.favicon.hash) and FOFA (icon_hash) search
+// by: the MurmurHash3 of the icon encoded like Python's base64.encodebytes, with a newline
+// every 76 characters and at the end
+func faviconHash(icon []byte) int32 {
+	encoded := base64.StdEncoding.EncodeToString(icon)
+	var b strings.Builder
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\n")
+	return int32(murmur3([]byte(b.String())))
+}
+
+// murmur3 is the 32-bit x86 MurmurHash3 of data, with a seed of 0
+func murmur3(data []byte) uint32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	var h uint32
+	n := len(data) / 4 * 4
+	for i := 0; i < n; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	var k uint32
+	switch len(data) - n {
+	case 3:
+		k ^= uint32(data[n+2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[n+1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[n])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMurmur3(t *testing.T) {
+	tests := []struct {
+		input string
+		hash  uint32
+	}{
+		{"", 0},
+		{"foo", 0xf6a5c420},
+		{"hello", 613153351},
+		{"abc", 3017643002},
+		{"The quick brown fox jumps over the lazy dog", 0x2e4ff723},
+	}
+	for _, test := range tests {
+		if hash := murmur3([]byte(test.input)); hash != test.hash {
+			t.Errorf("murmur3(%q) = %d, want %d", test.input, hash, test.hash)
+		}
+	}
+}
+
+func TestFaviconHash(t *testing.T) {
+	tests := []struct {
+		name    string
+		icon    []byte
+		encoded string
+	}{
+		{"short", []byte("icon"), "aWNvbg==\n"},
+		// base64.encodebytes wraps lines at 76 characters
+		{"one line", []byte(strings.Repeat("a", 57)), strings.Repeat("YWFh", 19) + "\n"},
+		{"two lines", []byte(strings.Repeat("a", 58)), strings.Repeat("YWFh", 19) + "\nYQ==\n"},
+	}
+	for _, test := range tests {
+		if hash, want := faviconHash(test.icon), int32(murmur3([]byte(test.encoded))); hash != want {
+			t.Errorf("%s: faviconHash = %d, want %d", test.name, hash, want)
+		}
+	}
+}
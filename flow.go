@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// flowStep is one request of a login flow. Prompts are asked on the terminal before the request is
+// sent, and Extract captures the first group of each regex from the response headers and body.
+// Any {{name}} in the URL, headers or body is replaced by the prompted or captured value.
+type flowStep struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	Prompt  map[string]string `json:"prompt"`
+	Extract map[string]string `json:"extract"`
+}
+
+// loginFlow is a sequence of requests run before crawling to log in. Its cookies are handed over
+// to the crawler, and its headers are sent with every request once the flow has run.
+type loginFlow struct {
+	Steps   []flowStep        `json:"steps"`
+	Headers map[string]string `json:"headers"`
+
+	mu      sync.RWMutex
+	current map[string]string
+}
+
+// loadLoginFlow reads a login flow from a JSON file
+func loadLoginFlow(filename string) (*loginFlow, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	flow := &loginFlow{}
+	if err := json.Unmarshal(data, flow); err != nil {
+		return nil, err
+	}
+	for i, step := range flow.Steps {
+		if step.URL == "" {
+			return nil, fmt.Errorf("step %d has no url", i+1)
+		}
+		for name, raw := range step.Extract {
+			if _, err := regexp.Compile(raw); err != nil {
+				return nil, fmt.Errorf("step %d: bad extract regex for %s: %v", i+1, name, err)
+			}
+		}
+	}
+	return flow, nil
+}
+
+// run performs the flow's requests in order, then gives its cookies to the collector
+func (f *loginFlow) run(transport http.RoundTripper, c *colly.Collector) error {
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Transport: transport, Jar: jar}
+	vars := make(map[string]string)
+	expand := func(s string) string {
+		for name, value := range vars {
+			s = strings.ReplaceAll(s, "{{"+name+"}}", value)
+		}
+		return s
+	}
+
+	for i, step := range f.Steps {
+		for name, message := range step.Prompt {
+			value, err := promptTerminal(message)
+			if err != nil {
+				return err
+			}
+			vars[name] = value
+		}
+		method := step.Method
+		if method == "" {
+			method = "GET"
+		}
+		req, err := http.NewRequest(method, expand(step.URL), strings.NewReader(expand(step.Body)))
+		if err != nil {
+			return fmt.Errorf("step %d: %v", i+1, err)
+		}
+		for header, value := range headers {
+			req.Header.Set(header, value)
+		}
+		for header, value := range step.Headers {
+			req.Header.Set(header, expand(value))
+		}
+		if step.Body != "" && req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("step %d: %v", i+1, err)
+		}
+		var dump strings.Builder
+		resp.Header.Write(&dump)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("step %d: %v", i+1, err)
+		}
+		dump.Write(body)
+
+		for name, raw := range step.Extract {
+			m := regexp.MustCompile(raw).FindStringSubmatch(dump.String())
+			if len(m) < 2 {
+				return fmt.Errorf("step %d: nothing captured for %s", i+1, name)
+			}
+			vars[name] = m[1]
+		}
+
+		// hand the session cookies over to the crawler
+		c.SetCookies(req.URL.String(), jar.Cookies(req.URL))
+	}
+
+	current := make(map[string]string)
+	for header, value := range f.Headers {
+		current[header] = expand(value)
+	}
+	f.mu.Lock()
+	f.current = current
+	f.mu.Unlock()
+	return nil
+}
+
+// apply sets the headers captured by the last run of the flow on a request
+func (f *loginFlow) apply(r *colly.Request) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for header, value := range f.current {
+		r.Headers.Set(header, value)
+	}
+}
+
+// promptTerminal asks the user for a value, e.g. a one-time password. The terminal is used
+// rather than stdin, which may be a pipe.
+func promptTerminal(message string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		tty, err = os.Stdin, nil
+	} else {
+		defer tty.Close()
+	}
+	fmt.Fprint(os.Stderr, message)
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
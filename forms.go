@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Anti-CSRF headers to send along with form submissions, keyed by the form's action URL
+var csrfHeaders sync.Map
+
+// Fill values loaded from -form-profile, keyed by lowercased input name or type
+var fillProfile map[string]string
+
+// csrfMetaNames are the meta tags frameworks use to hand anti-CSRF tokens to JavaScript
+var csrfMetaNames = []string{"csrf-token", "_csrf", "csrf_token", "xsrf-token"}
+
+// submitForms fills in and submits every form of a page, carrying over hidden inputs such as
+// anti-CSRF tokens, and sending tokens found in meta tags as a header.
+func submitForms(e *colly.HTMLElement) {
+	var token, tokenParam string
+	tokenHeader := "X-CSRF-Token"
+	e.ForEach("meta[name]", func(_ int, meta *colly.HTMLElement) {
+		name := strings.ToLower(meta.Attr("name"))
+		for _, csrfName := range csrfMetaNames {
+			if name == csrfName {
+				token = meta.Attr("content")
+			}
+		}
+		switch name {
+		case "csrf-param":
+			tokenParam = meta.Attr("content")
+		case "_csrf_header":
+			tokenHeader = meta.Attr("content")
+		}
+	})
+
+	e.ForEach("form", func(_ int, form *colly.HTMLElement) {
+		action := form.Request.AbsoluteURL(form.Attr("action"))
+		if form.Attr("action") == "" {
+			action = form.Request.URL.String()
+		}
+		if action == "" {
+			return
+		}
+
+		values := formValues(form)
+		if tokenParam != "" && token != "" && values.Get(tokenParam) == "" {
+			values.Set(tokenParam, token)
+		}
+
+		if strings.EqualFold(form.Attr("method"), "post") {
+			if token != "" {
+				csrfHeaders.Store(action, map[string]string{tokenHeader: token})
+			}
+			data := make(map[string]string)
+			for name := range values {
+				data[name] = values.Get(name)
+			}
+			form.Request.Post(action, data)
+		} else {
+			u, err := url.Parse(action)
+			if err != nil {
+				return
+			}
+			u.RawQuery = values.Encode()
+			queueVisit(form.Request, u.String())
+		}
+	})
+}
+
+// formValues fills in the inputs of a form, keeping the values they already have
+func formValues(form *colly.HTMLElement) url.Values {
+	values := url.Values{}
+	form.ForEach("input[name], textarea[name], select[name]", func(_ int, input *colly.HTMLElement) {
+		name := input.Attr("name")
+		inputType := strings.ToLower(input.Attr("type"))
+		switch {
+		case inputType == "hidden":
+			// carry hidden inputs over untouched, as that is where anti-CSRF tokens live
+			values.Set(name, input.Attr("value"))
+		case inputType == "submit" || inputType == "button" || inputType == "image" || inputType == "file":
+		case input.Name == "select":
+			values.Set(name, input.ChildAttr("option", "value"))
+		case input.Attr("value") != "":
+			values.Set(name, input.Attr("value"))
+		default:
+			values.Set(name, fillValue(name, inputType))
+		}
+	})
+	return values
+}
+
+// formQueryURL returns the URL a GET form submits to, with its inputs filled in, or the bare
+// action URL of other forms and of forms without inputs. Pseudo-URL actions are returned as they are.
+func formQueryURL(form *colly.HTMLElement) string {
+	if pseudoScheme(form.Attr("action")) != "" {
+		return form.Attr("action")
+	}
+	action := form.Request.AbsoluteURL(form.Attr("action"))
+	if form.Attr("method") != "" && !strings.EqualFold(form.Attr("method"), "get") {
+		return action
+	}
+	values := formValues(form)
+	u, err := url.Parse(action)
+	if err != nil || len(values) == 0 {
+		return action
+	}
+	u.RawQuery = values.Encode()
+	return u.String()
+}
+
+// addCSRFHeaders sets the anti-CSRF headers found for a form on the request submitting it
+func addCSRFHeaders(r *colly.Request) {
+	if r.Method != "POST" {
+		return
+	}
+	if h, ok := csrfHeaders.Load(r.URL.String()); ok {
+		for header, value := range h.(map[string]string) {
+			r.Headers.Set(header, value)
+		}
+	}
+}
+
+// loadFillProfile reads a YAML mapping of input names or types to fill values into fillProfile.
+// Only flat "key: value" lines are understood, which is all a profile needs. E.g.
+//
+//	email: tester@example.com
+//	phone: "+15555555555"
+func loadFillProfile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fillProfile = make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || text == "---" {
+			continue
+		}
+		i := strings.Index(text, ":")
+		if i <= 0 {
+			return fmt.Errorf("line %d: expected \"key: value\"", line)
+		}
+		key := strings.ToLower(strings.Trim(strings.TrimSpace(text[:i]), `"'`))
+		value := strings.TrimSpace(text[i+1:])
+		switch {
+		case strings.HasPrefix(value, `"`):
+			value, err = strconv.Unquote(value)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+		case strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") && len(value) > 1:
+			value = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		default:
+			if j := strings.Index(value, " #"); j >= 0 {
+				value = strings.TrimSpace(value[:j])
+			}
+		}
+		fillProfile[key] = value
+	}
+	return scanner.Err()
+}
+
+// fillValue picks a plausible value for a form input from its name and type. Values from the
+// -form-profile file take precedence: an exact name match first, then the input's type.
+func fillValue(name string, inputType string) string {
+	name = strings.ToLower(name)
+	if value, ok := fillProfile[name]; ok {
+		return value
+	}
+	if value, ok := fillProfile[inputType]; ok && inputType != "" {
+		return value
+	}
+	switch {
+	case inputType == "email" || strings.Contains(name, "mail"):
+		return "test@example.com"
+	case inputType == "number" || inputType == "range":
+		return "1"
+	case inputType == "tel" || strings.Contains(name, "phone"):
+		return "5555555555"
+	case inputType == "url":
+		return "https://example.com/"
+	case inputType == "date":
+		return "2000-01-01"
+	case inputType == "checkbox" || inputType == "radio":
+		return "on"
+	case inputType == "password":
+		return "Password123!"
+	}
+	return "test"
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// requeueBatch is how many spilled requests are queued back at a time
+const requeueBatch = 100
+
+// frontier holds back the links found while the heap is over -max-memory. colly starts a
+// goroutine for every request it queues, so rather than being queued, they are spilled to a
+// file, and queued back a batch at a time as memory is freed.
+type frontier struct {
+	guard *memoryGuard
+	c     *colly.Collector
+	mu    sync.Mutex
+	// the spill file, written to at its end and read back from its start
+	file     *os.File
+	readFile *os.File
+	reader   *bufio.Reader
+	// spilled is how many requests the file holds that have not been read back
+	spilled int64
+	stop    chan struct{}
+}
+
+// The frontier of the collector crawling the current target, set with -max-memory, or nil
+var crawlFrontier *frontier
+
+// newFrontier starts queueing the spilled requests of a collector back while the heap is under the limit
+func newFrontier(guard *memoryGuard, c *colly.Collector) *frontier {
+	f := &frontier{guard: guard, c: c, stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-f.stop:
+				return
+			case <-ticker.C:
+				if atomic.LoadInt32(&guard.over) == 0 {
+					f.requeue()
+				}
+			}
+		}
+	}()
+	return f
+}
+
+// queueVisit visits a link found on the page of r, like r.Visit, unless the crawl frontier
+// holds it back
+func queueVisit(r *colly.Request, link string) error {
+	if crawlFrontier == nil {
+		return r.Visit(link)
+	}
+	return crawlFrontier.visit(r, link)
+}
+
+// visit visits a link found on the page of r, or spills it while memory is short, or while
+// requests spilled before it are still waiting
+func (f *frontier) visit(r *colly.Request, link string) error {
+	f.mu.Lock()
+	if atomic.LoadInt32(&f.guard.over) == 0 && f.spilled == 0 {
+		f.mu.Unlock()
+		return r.Visit(link)
+	}
+	defer f.mu.Unlock()
+	u, err := url.Parse(r.AbsoluteURL(link))
+	if err != nil || u.Host == "" {
+		return r.Visit(link)
+	}
+	data, err := (&colly.Request{URL: u, Method: "GET", Depth: r.Depth + 1, Ctx: r.Ctx, Headers: &http.Header{}}).Marshal()
+	if err != nil {
+		return err
+	}
+	if f.file == nil {
+		// without a spill file, links are queued anyway
+		file, err := os.CreateTemp("", "hakrawler-frontier-")
+		if err != nil {
+			return r.Visit(link)
+		}
+		readFile, err := os.Open(file.Name())
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return r.Visit(link)
+		}
+		f.file, f.readFile, f.reader = file, readFile, bufio.NewReader(readFile)
+	}
+	if _, err := f.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	f.spilled++
+	if stats != nil {
+		atomic.AddInt64(&stats.queued, 1)
+	}
+	return nil
+}
+
+// size returns how many requests are spilled
+func (f *frontier) size() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.spilled
+}
+
+// requeue queues a batch of spilled requests back, and returns how many
+func (f *frontier) requeue() int {
+	f.mu.Lock()
+	var requests []*colly.Request
+	for f.spilled > 0 && len(requests) < requeueBatch {
+		line, err := f.reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		f.spilled--
+		if stats != nil {
+			atomic.AddInt64(&stats.queued, -1)
+		}
+		if r, err := f.c.UnmarshalRequest(line); err == nil {
+			requests = append(requests, r)
+		}
+	}
+	// start the file over once it has been read back
+	if f.spilled == 0 && f.file != nil {
+		f.file.Truncate(0)
+		f.file.Seek(0, 0)
+		f.readFile.Seek(0, 0)
+		f.reader.Reset(f.readFile)
+	}
+	f.mu.Unlock()
+
+	for _, r := range requests {
+		r.Do()
+	}
+	return len(requests)
+}
+
+// wait blocks until the collector is done, with the spilled requests. Once nothing is in
+// flight, they are queued back even while the heap is still over the limit.
+func (f *frontier) wait() {
+	for {
+		f.c.Wait()
+		if f.requeue() == 0 && f.size() == 0 {
+			return
+		}
+	}
+}
+
+// close stops queueing requests back, and removes the spill file
+func (f *frontier) close() {
+	close(f.stop)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		f.file.Close()
+		f.readFile.Close()
+		os.Remove(f.file.Name())
+	}
+}
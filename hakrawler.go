@@ -2,18 +2,27 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/gocolly/colly/v2"
@@ -22,190 +31,1956 @@ import (
 type Result struct {
 	Source string
 	URL    string
+	Method string `json:",omitempty"`
+	// Status and ContentType describe the response, for results about a fetched page
+	Status      int               `json:",omitempty"`
+	ContentType string            `json:",omitempty"`
+	OriginalURL string            `json:",omitempty"`
+	Match       string            `json:",omitempty"`
+	Groups      map[string]string `json:",omitempty"`
+	// Parameter and Contexts tell which parameter -reflect found reflected, and where
+	Parameter string   `json:",omitempty"`
+	Contexts  []string `json:",omitempty"`
+	// RedirectParams are the query parameters holding URLs or domains
+	RedirectParams []string `json:",omitempty"`
+	// Category and Severity are set on results that are findings, e.g. open-redirect-candidate and medium
+	Category string `json:",omitempty"`
+	Severity string `json:",omitempty"`
+	// ContentLength is reported for responses that -size truncated or skipped
+	ContentLength int64 `json:",omitempty"`
+	// AllowOrigin and AllowCredentials are the CORS headers of the response, for -cors results
+	AllowOrigin      string `json:",omitempty"`
+	AllowCredentials bool   `json:",omitempty"`
+	// Headers are the response headers chosen with -include-headers
+	Headers map[string]string `json:",omitempty"`
+	// TTFB and Duration are how many milliseconds the request took with -timing, until the
+	// first byte of the response and until its body was read
+	TTFB     float64 `json:",omitempty"`
+	Duration float64 `json:",omitempty"`
+	// IP is the address the host of the URL resolves to, with -show-ip
+	IP string `json:",omitempty"`
+	// BodyType and BodyParams are the content type of the body of -xhr requests, and the
+	// parameter or key names it holds
+	BodyType   string   `json:",omitempty"`
+	BodyParams []string `json:",omitempty"`
+}
+
+// stringList is a flag that can be repeated to collect several values
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
 var headers map[string]string
 
-// Thread safe map
-var sm sync.Map
+// Extra headers per host pattern, loaded from -headers-file
+var hostHeaders map[string]map[string]string
+
+// Thread safe map
+var sm sync.Map
+
+// Sources to output, or nil to output everything
+var outputSources map[string]bool
+
+// Template of output lines set with -template, or nil
+var outputTemplate *template.Template
+
+// Fields of JSON output set with -fields, or nil for all of them
+var outputFields []string
+
+// Whether to output only URLs with redirect parameters
+var redirectsOnly bool
+
+// Whether to drop javascript:, mailto:, tel: and data: links, and whether to keep mailto: ones anyway
+var skipPseudo, keepMailto bool
+
+// Whether to output only the first URL of every host, and the hosts output so far
+var uniqueHosts bool
+var hostsSeen sync.Map
+
+// Whether to output every line only once, set with -unique
+var uniqueLines bool
+
+// URLs known from earlier recon, set with -exclude-urls, which are neither output nor crawled
+var excludedURLs map[string]bool
+
+// Number of results output so far, and the number at which the current target's crawl stops
+// with -max-results, or 0
+var resultsSent int64
+var resultLimit int64
+
+// Lowest severity to output, set with -min-severity, or -1 to output everything
+var minSeverity = -1
+
+// Per host result files written with -output-dir, or nil
+var hostOutput *hostFiles
+
+// Response headers to attach to the results about fetched pages, set with -include-headers
+var includedHeaders []string
+
+// Links held back by -show-final until their response arrives, keyed by absolute URL
+var pending sync.Map
+
+// Original URL of every request, keyed by request ID
+var origins sync.Map
+
+func main() {
+	urll := flag.String(("u"), "", "the url to crawl")
+	threads := flag.Int("t", 8, "Number of threads to utilise.")
+	depth := flag.Int("d", 2, "Depth to crawl.")
+	maxSize := flag.Int("size", -1, "Page size limit, in KB.")
+	insecure := flag.Bool("insecure", false, "Disable TLS verification.")
+	subsInScope := flag.Bool("subs", false, "Include subdomains for crawling.")
+	respectRobots := flag.Bool("respect-robots", false, "Do not visit the URLs disallowed by robots.txt for the crawler's user agent, and wait its Crawl-delay between requests to a host.")
+	burpScope := flag.String("burp-scope", "", "Target scope exported from Burp Suite as JSON, whose include and exclude rules replace the default scope of the target's domain.")
+	scopeFile := flag.String("scope-file", "", "File of include and exclude scope rules, replacing the default scope of the target's domain.")
+	scopeCIDR := flag.String("scope-cidr", "", "Comma-separated IP ranges whose hosts are also in scope, by address or resolution. E.g. -scope-cidr 10.0.0.0/8")
+	scopePorts := flag.String("ports", "any", "Ports that links may be visited on: any, same (the target's port only), or a comma-separated list added to the target's port. E.g. -ports 8080,8443")
+	schemePolicy := flag.String("scheme", "follow", "Whether links may switch between http and https: follow, forbid (keep to the target's scheme) or https (upgrade http links to https on the ports -ports allows, falling back to http for hosts that do not answer over https).")
+	forceHTTPS := flag.Bool("force-https", false, "Upgrade http links to https before visiting them, falling back to http for hosts that do not answer over https. Same as -scheme https.")
+	excludeSubs := flag.String("exclude-subs", "", "Comma-separated prefixes of subdomains not to crawl with -subs. E.g. -exclude-subs cdn.,static.,img.")
+	showJson := flag.Bool("json", false, "Output as JSON.")
+	showSource := flag.Bool("s", false, "Show the source of URL based on where it was found. E.g. href, form, script, etc.")
+	showIP := flag.Bool("show-ip", false, "Show the IP address the host of every URL resolves to, after the URL, or as IP in JSON output. Hosts are resolved locally, with -doh and -host-map, even with -proxy.")
+	rawHeaders := flag.String(("h"), "", "Custom headers separated by two semi-colons. E.g. -h \"Cookie: foo=bar;;Referer: http://example.com/\" ")
+	headersFile := flag.String("headers-file", "", "JSON file mapping host patterns to custom headers. E.g. {\"*.example.com\": {\"Cookie\": \"foo=bar\"}}")
+	rawRequest := flag.String("request", "", "Raw HTTP request file (e.g. saved from Burp) to use as the template for the crawl. Its method and body are used for the first request, its headers for all of them.")
+	auth := flag.String("auth", "", "Basic or Digest authentication credentials. E.g. -auth user:pass")
+	ntlm := flag.String("ntlm", "", "NTLM authentication credentials. E.g. -ntlm DOMAIN\\user:pass")
+	negotiateCmd := flag.String("negotiate-cmd", "", "Command printing a base64 SPNEGO token for the SPN given as its last argument, used to answer Kerberos/Negotiate challenges.")
+	oauthTokenURL := flag.String("oauth-token-url", "", "OAuth2 token endpoint to get a bearer token from with the client credentials grant.")
+	oauthClientID := flag.String("oauth-client-id", "", "OAuth2 client ID, used with -oauth-token-url.")
+	oauthClientSecret := flag.String("oauth-client-secret", "", "OAuth2 client secret, used with -oauth-token-url.")
+	oauthScope := flag.String("oauth-scope", "", "Space-separated OAuth2 scopes to request, used with -oauth-token-url.")
+	tokenCmd := flag.String("token-cmd", "", "Command printing a fresh bearer token, run at startup if no Authorization header is given and whenever the current JWT is about to expire.")
+	cookiesFile := flag.String("cookies", "", "Netscape format cookies.txt file to load into the cookie jar.")
+	cookiesFromBrowser := flag.String("cookies-from-browser", "", "Load the target's cookies from a local browser profile: chrome or firefox. Requires the sqlite3 command.")
+	sessionCheckURL := flag.String("session-check-url", "", "URL to fetch periodically to check the crawl is still logged in. Used with -logged-out-regex.")
+	loggedOutRegex := flag.String("logged-out-regex", "", "Regex matching the -session-check-url response when logged out.")
+	sessionCheckInterval := flag.Int("session-check-interval", 60, "Seconds between session checks.")
+	formSubmit := flag.Bool("submit-forms", false, "Fill in and submit the forms found, carrying over anti-CSRF tokens.")
+	formProfile := flag.String("form-profile", "", "YAML file mapping input names or types to the values -submit-forms fills in. E.g. email: tester@example.com")
+	loginFlowFile := flag.String("login-flow", "", "JSON file describing a sequence of login requests to run before crawling, and again if the session is lost.")
+	fromHar := flag.String("from-har", "", "HAR file whose URLs are used as additional seeds, along with its cookies and authentication headers.")
+	fromBurp := flag.String("from-burp", "", "Burp items XML export, or ZAP URL export, whose URLs are used as additional seeds.")
+	nearDup := flag.Int("near-dup", -1, "Do not visit the links of pages whose content is within this many bits (out of 64) of an already crawled page. E.g. 3. (default -1, disabled)")
+	patternBudgetLimit := flag.Int("pattern-budget", -1, "Maximum number of URLs to visit per path template, e.g. /product/{id}. (default -1, unlimited)")
+	keepTraps := flag.Bool("keep-traps", false, "Visit URLs that look like crawler traps (repeating paths, session IDs in paths, calendar dates years ahead) instead of pruning them.")
+	logoutRegex := flag.String("logout-regex", `(?i)(log|sign)[-_]?(out|off)|session[-_]?(kill|end|destroy)|end[-_]?session`, "Regex of URLs not to visit when crawling with credentials, so the session is not killed. Empty to visit them anyway.")
+	denyRegex := flag.String("deny-regex", `(?i)(^|[/_.?&=-])(delete|remove|destroy|disable|deactivate|purchase|checkout|pay|transfer|unsubscribe|cancel|reset)([/_.?&=-]|$)`, "Regex of state-changing URLs to output but never visit when crawling with credentials. Empty to visit them anyway.")
+	dumpTraffic := flag.String("dump-traffic", "", "File to write every request and response to, as JSON lines.")
+	chromeBinary := flag.String("chrome", "", "Path to the Chrome or Chromium binary used to render pages. Looked up in the PATH by default.")
+	chromeNoSandbox := flag.Bool("chrome-no-sandbox", false, "Run headless Chrome without its sandbox, for containers where it cannot start otherwise. Risky: a crawled page exploiting Chrome then runs code with the crawler's privileges. Always the case when running as root.")
+	screenshotDir := flag.String("screenshot", "", "Directory to save a screenshot of every crawled page to, rendered with headless Chrome. Screenshots are listed in index.jsonl.")
+	domDir := flag.String("dom-snapshot", "", "Directory to save the DOM of every crawled page to, as rendered by headless Chrome. Snapshots are listed in index.jsonl.")
+	parseJS := flag.Bool("js", false, "Download in-scope JavaScript files and inline scripts, and extract client-side routes, API endpoints, webpack chunks, service workers and upload endpoints from them.")
+	captureXHR := flag.Bool("xhr", false, "Render every crawled page with headless Chrome and output the XHR/fetch requests it makes, with the content type and parameter names of their bodies.")
+	maxScrolls := flag.Int("scroll", 0, "Maximum number of times to scroll pages down by a viewport when rendering them with headless Chrome, to load lazy content and infinite scroll batches. Scrolling stops once a page stops growing.")
+	click := flag.Bool("click", false, "Experimental: render every crawled page with headless Chrome, click its buttons and the elements with click handlers, and output and visit the URLs the clicks navigate to and the XHR/fetch requests they make. Elements labelled like -deny-regex are not clicked.")
+	maxClicks := flag.Int("max-clicks", 20, "Maximum number of elements clicked per page with -click.")
+	clickTime := flag.Int("click-time", 10000, "Time in milliseconds -click spends clicking through each page, at most.")
+	idleTime := flag.Int("idle", 5000, "Time in milliseconds given to page scripts once a page has loaded, and after every scroll, when rendering pages with headless Chrome.")
+	onlyRedirects := flag.Bool("redirects", false, "Only output URLs whose query parameters hold URLs or domains, as open redirect and SSRF candidates.")
+	reflectParams := flag.Bool("reflect", false, "Append a harmless canary to the query parameters of crawled URLs and output those reflected in the response, and where.")
+	corsHeaders := flag.Bool("cors", false, "Output the Access-Control-Allow-Origin and -Credentials headers of every crawled URL that sends them. Wildcard or null origins allowed with credentials are flagged as findings.")
+	parserNames := flag.String("parsers", defaultParsers, "Comma-separated content parsers to extract links with: html, css, js, json, xml and pdf. The responses the parsers given handle are downloaded whatever -visit-mime says. -js adds js. See parsers.go.")
+	processorNames := flag.String("processors", "", "Comma-separated names of the compiled-in processors to run. See processors.go.")
+	scriptCmd := flag.String("script", "", "Command run alongside the crawl, fed every request and response as JSON lines, that can drop requests, set headers and output extra results. See script.go.")
+	templateText := flag.String("template", "", "Go template of output lines, given the result. E.g. -template '{{.Source}},{{.URL}},{{.Status}}'")
+	includeHeaders := flag.String("include-headers", "", "Comma-separated response headers to output the values of, in a response result for every visited URL, or on the links of -show-final. E.g. -include-headers server,x-powered-by,via")
+	timing := flag.Bool("timing", false, "Output the time to first byte and total duration of requests in milliseconds, in a response result for every visited URL, or on the links of -show-final.")
+	fields := flag.String("fields", "", "Comma-separated fields to include in JSON output, in that order. E.g. -fields url,status,content-type")
+	unique := flag.Bool(("unique"), false, "Show only unique urls.")
+	noPseudo := flag.Bool("no-pseudo", false, "Do not output javascript:, mailto:, tel: and data: links.")
+	emails := flag.Bool("emails", false, "Output mailto: links as email results, even with -no-pseudo.")
+	uniqueHost := flag.Bool("unique-host", false, "Show only the first url of every hostname.")
+	maxResults := flag.Int("max-results", -1, "Stop crawling a target once this many results have been output for it. (default -1, unlimited)")
+	outputDir := flag.String("output-dir", "", "Directory to also write the results of every hostname to, in a file named after it. E.g. out/app.example.com.txt")
+	outputFile := flag.String("o", "", "File to write results to instead of stdout. Compressed with gzip if the name ends in .gz, e.g. -o results.jsonl.gz")
+	teeFile := flag.String("tee", "", "File to also write results to, while still writing them to stdout. Compressed with gzip if the name ends in .gz.")
+	jsonArray := flag.Bool("json-array", false, "Output as a single JSON array instead of JSON lines.")
+	reportFile := flag.String("report", "", "File to write a self-contained HTML report of the results to once the crawl is over.")
+	reportMarkdown := flag.String("report-md", "", "File to write a Markdown summary of the hosts and findings to once the crawl is over.")
+	headerAuditFile := flag.String("header-audit", "", "File to write a Markdown table of the security headers (HSTS, X-Frame-Options, CSP, X-Content-Type-Options) missing or weak on the crawled pages of every host to once the crawl is over.")
+	excludeURLsFile := flag.String("exclude-urls", "", "Output of earlier recon, plain or JSON, whose URLs are neither output nor crawled, to only report new ones.")
+	baselineFile := flag.String("baseline", "", "Output of an earlier run, plain or JSON, to list the new URLs of in the -report-md summary.")
+	minSeverityName := flag.String("min-severity", "", "Only output findings of at least this severity: info, low, medium or high.")
+	errorLogFile := flag.String("error-log", "", "File to write every failed request to, as JSON lines with the URL, error type and attempt count.")
+	retryFailed := flag.Bool("retry-failed", false, "Once a target is crawled, try again the requests that failed with transient errors, one at a time.")
+	checkpointFile := flag.String("checkpoint", "", "File to save the visited and pending URLs to periodically, to resume the crawl from with -resume.")
+	checkpointInterval := flag.Duration("checkpoint-interval", 60*time.Second, "How often to save the -checkpoint file. E.g. -checkpoint-interval 5m")
+	resumeFile := flag.String("resume", "", "Checkpoint file to resume a crawl from: its pending URLs are crawled and its visited URLs skipped.")
+	sorted := flag.Bool("sorted", false, "Output results sorted and deduplicated once the crawl is over.")
+	proxy := flag.String(("proxy"), "", "Proxy URL. E.g. -proxy http://127.0.0.1:8080")
+	maxBandwidth := flag.String("max-bandwidth", "", "Maximum total download rate. E.g. -max-bandwidth 2MB/s")
+	maxMemory := flag.String("max-memory", "", "Hold back new requests while the heap is over this size, spilling the links found to a temporary file until memory is freed. E.g. -max-memory 2GB")
+	pprofAddr := flag.String("pprof", "", "Address to serve runtime profiles (/debug/pprof/) and the crawl status (/status) on. E.g. -pprof localhost:6060")
+	summaryFile := flag.String("summary", "", "File to write a JSON summary of the crawl to once it is over, with the pages visited, results by source, error rate and average latency of every host.")
+	favicon := flag.Bool("favicon", false, "Fetch the /favicon.ico of every crawled host once, and add its mmh3 hash, as searched by Shodan and FOFA, to the -summary file.")
+	metricsAddr := flag.String("metrics", "", "Address to serve Prometheus metrics (/metrics) on. E.g. -metrics :9090")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OpenTelemetry collector to send a span per request to, over OTLP/HTTP. E.g. -otlp-endpoint http://localhost:4318")
+	controlFile := flag.String("control-file", "", "JSON file of settings applied while crawling, and again whenever it changes. E.g. {\"parallelism\": 2, \"delay_ms\": 500}")
+	dohEndpoint := flag.String("doh", "", "DNS over HTTPS server to resolve hostnames with instead of the system resolver. E.g. -doh https://cloudflare-dns.com/dns-query")
+	var hostMappings stringList
+	flag.Var(&hostMappings, "host-map", "Hostname to connect to a given IP for, whatever DNS says. E.g. -host-map staging.example.com=10.1.2.3. Can be repeated.")
+	hostMapFile := flag.String("host-map-file", "", "File of hostname=IP mappings, or of lines in the /etc/hosts format, to use like -host-map.")
+	proxyFile := flag.String("proxy-file", "", "JSON file mapping host patterns to proxy URLs, or to \"direct\". Other hosts use -proxy. E.g. {\"*.corp.local\": \"socks5://127.0.0.1:1080\"}")
+	rawProxyHeaders := flag.String("proxy-header", "", "Headers to send on CONNECT requests to the proxy, separated by two semi-colons. E.g. -proxy-header \"Proxy-Authorization: Basic dXNlcjpwYXNz;;X-Tenant: acme\"")
+	timeout := flag.Int("timeout", -1, "Maximum time to crawl each URL from stdin, in seconds.")
+	disableRedirects := flag.Bool("dr", false, "Disable following HTTP redirects.")
+	visitMime := flag.String("visit-mime", "text/html,application/xhtml+xml", "Comma-separated Content-Types to download and crawl. URLs of other types are still output, and downloaded if one of -parsers extracts links from them. Empty to crawl everything.")
+	headFirst := flag.Bool("head-first", false, "Send a HEAD request for URLs with unknown extensions and skip them if they are too large or not crawlable.")
+	matchString := flag.String("match-string", "", "Only output pages whose response body contains this string.")
+	matchRegex := flag.String("match-regex", "", "Only output pages whose response body matches this regex.")
+	stopOnMatch := flag.String("stop-on-match", "", "Stop crawling a target as soon as a URL or response body matches this regex, and output it.")
+	var extractRegexes stringList
+	flag.Var(&extractRegexes, "extract-regex", "Output every match of this regex in response bodies. Named groups are included in JSON output, and matches with a group named secret are high severity findings. Can be repeated.")
+	showFinal := flag.Bool("show-final", false, "Show the final URL after redirects instead of the discovered one. The original URL is kept in JSON output.")
+
+	flag.Parse()
+	redirectsOnly = *onlyRedirects
+	if *minSeverityName != "" {
+		minSeverity = severityLevel(*minSeverityName)
+		if severities[minSeverity] != *minSeverityName {
+			fmt.Fprintln(os.Stderr, "Error: unknown severity", *minSeverityName)
+			os.Exit(1)
+		}
+	}
+	if *jsonArray {
+		*showJson = true
+	}
+	uniqueHosts = *uniqueHost
+	uniqueLines = *unique
+	skipPseudo, keepMailto = *noPseudo, *emails
+	if *excludeURLsFile != "" {
+		var err error
+		excludedURLs, err = loadBaseline(*excludeURLsFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading excluded URLs:", err)
+			os.Exit(1)
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if *outputFile != "" {
+		file, err := createOutput(*outputFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error creating output file:", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		out = file
+	}
+	if *teeFile != "" {
+		file, err := createOutput(*teeFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error creating tee file:", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		out = io.MultiWriter(out, file)
+	}
+
+	var failures *errorLog
+	if *errorLogFile != "" {
+		file, err := os.Create(*errorLogFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error creating error log:", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		failures = newErrorLog(file)
+	}
+
+	if *reportFile != "" || *reportMarkdown != "" {
+		report = &crawlReport{}
+	}
+	if *reportFile != "" {
+		defer func() {
+			if err := report.writeHTML(*reportFile); err != nil {
+				fmt.Fprintln(os.Stderr, "Error writing report:", err)
+			}
+		}()
+	}
+	if *reportMarkdown != "" {
+		var baseline map[string]bool
+		if *baselineFile != "" {
+			var err error
+			baseline, err = loadBaseline(*baselineFile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error reading baseline:", err)
+				os.Exit(1)
+			}
+		}
+		defer func() {
+			if err := report.writeMarkdown(*reportMarkdown, baseline); err != nil {
+				fmt.Fprintln(os.Stderr, "Error writing Markdown report:", err)
+			}
+		}()
+	}
+
+	var audit *headerAudit
+	if *headerAuditFile != "" {
+		audit = newHeaderAudit()
+		defer func() {
+			if err := audit.writeMarkdown(*headerAuditFile); err != nil {
+				fmt.Fprintln(os.Stderr, "Error writing header audit:", err)
+			}
+		}()
+	}
+
+	// hosts whose favicon -favicon has requested
+	var faviconHosts sync.Map
+
+	if *outputDir != "" {
+		var err error
+		hostOutput, err = newHostFiles(*outputDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error creating output directory:", err)
+			os.Exit(1)
+		}
+		defer hostOutput.close()
+	}
+
+	if *fields != "" {
+		var err error
+		outputFields, err = parseFields(*fields)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing fields:", err)
+			os.Exit(1)
+		}
+	}
+
+	for _, name := range strings.Split(*includeHeaders, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			includedHeaders = append(includedHeaders, http.CanonicalHeaderKey(name))
+		}
+	}
+
+	if *timing {
+		requestTimings = newTimingRecorder()
+	}
+
+	if *templateText != "" {
+		var err error
+		outputTemplate, err = template.New("output").Parse(*templateText)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing output template:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *proxy != "" {
+		os.Setenv("PROXY", *proxy)
+	}
+	proxyURL, _ := url.Parse(os.Getenv("PROXY"))
+
+	var limiter *bandwidthLimiter
+	if *maxBandwidth != "" {
+		rate, err := parseBandwidth(*maxBandwidth)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing bandwidth:", err)
+			os.Exit(1)
+		}
+		limiter = newBandwidthLimiter(rate)
+	}
+
+	if *pprofAddr != "" || *metricsAddr != "" || *summaryFile != "" {
+		stats = newCrawlStats()
+	}
+	if *favicon && *summaryFile == "" {
+		fmt.Fprintln(os.Stderr, "-favicon requires -summary")
+		os.Exit(1)
+	}
+	if *summaryFile != "" {
+		defer func() {
+			if err := stats.writeSummary(*summaryFile); err != nil {
+				fmt.Fprintln(os.Stderr, "Error writing summary:", err)
+			}
+		}()
+	}
+	if *pprofAddr != "" {
+		go serveDiagnostics(*pprofAddr)
+	}
+	if *metricsAddr != "" {
+		go serveMetrics(*metricsAddr)
+	}
+
+	var tracer *otlpExporter
+	if *otlpEndpoint != "" {
+		tracer = newOTLPExporter(*otlpEndpoint)
+		defer tracer.flush()
+	}
+
+	// SIGUSR1 pauses the crawl and SIGUSR2 resumes it
+	handlePauseSignals()
+
+	var control *rateControl
+	if *controlFile != "" {
+		var err error
+		control, err = newRateControl(*controlFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading control file:", err)
+			os.Exit(1)
+		}
+	}
+
+	var checkpoint *crawlCheckpoint
+	if *checkpointFile != "" {
+		checkpoint = newCrawlCheckpoint(*checkpointFile)
+		go checkpoint.run(*checkpointInterval)
+		defer func() {
+			if err := checkpoint.save(); err != nil {
+				log.Println("Error saving checkpoint:", err)
+			}
+		}()
+	}
+
+	var resolver *dohResolver
+	if *dohEndpoint != "" {
+		if u, err := url.Parse(*dohEndpoint); err != nil || u.Scheme != "https" || u.Host == "" {
+			fmt.Fprintln(os.Stderr, "Error: -doh must be an https URL, e.g. https://cloudflare-dns.com/dns-query")
+			os.Exit(1)
+		}
+		resolver = newDoHResolver(*dohEndpoint)
+	}
+
+	var hosts hostMap
+	if len(hostMappings) > 0 || *hostMapFile != "" {
+		hosts = make(hostMap)
+		for _, mapping := range hostMappings {
+			if err := hosts.add(mapping); err != nil {
+				fmt.Fprintln(os.Stderr, "Error parsing host mapping:", err)
+				os.Exit(1)
+			}
+		}
+		if *hostMapFile != "" {
+			if err := hosts.load(*hostMapFile); err != nil {
+				fmt.Fprintln(os.Stderr, "Error parsing host map file:", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if *showIP {
+		resultIPs = &ipResolver{hosts: hosts, doh: resolver}
+	}
+
+	var robotsDelays *crawlDelays
+	if *respectRobots {
+		robotsDelays = newCrawlDelays()
+	}
+
+	var memory *memoryGuard
+	if *maxMemory != "" {
+		size, err := parseSize(*maxMemory)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing memory limit:", err)
+			os.Exit(1)
+		}
+		memory = newMemoryGuard(uint64(size))
+	}
+
+	var proxyHeaders http.Header
+	if *rawProxyHeaders != "" {
+		var err error
+		proxyHeaders, err = parseProxyHeaders(*rawProxyHeaders)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing proxy headers:", err)
+			os.Exit(1)
+		}
+	}
+
+	var routes *proxyRoutes
+	if *proxyFile != "" {
+		var fallback *url.URL
+		if *proxy != "" {
+			fallback = proxyURL
+		}
+		var err error
+		routes, err = loadProxyRoutes(*proxyFile, fallback)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing proxy file:", err)
+			os.Exit(1)
+		}
+		routes.connectHeaders = proxyHeaders
+	}
+
+	// Convert the headers input to a usable map (or die trying)
+	err := parseHeaders(*rawHeaders)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error parsing headers:", err)
+		os.Exit(1)
+	}
+
+	// Use a raw request file as the template for the crawl
+	var requestTemplate *http.Request
+	var templateBody []byte
+	if *rawRequest != "" {
+		requestTemplate, templateBody, err = parseRawRequest(*rawRequest)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing request file:", err)
+			os.Exit(1)
+		}
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		// headers given with -h take precedence over the template
+		for header := range requestTemplate.Header {
+			if _, ok := headers[header]; !ok && header != "Content-Length" {
+				headers[header] = requestTemplate.Header.Get(header)
+			}
+		}
+		if _, ok := headers["Host"]; !ok {
+			headers["Host"] = requestTemplate.Host
+		}
+		if *urll == "" {
+			*urll = "https://" + requestTemplate.Host + requestTemplate.RequestURI
+		}
+	}
+
+	// Load the cookies to start the crawl with
+	var cookies []jarCookie
+	if *cookiesFile != "" {
+		cookies, err = parseCookiesFile(*cookiesFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing cookies file:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *cookiesFromBrowser != "" {
+		hostname, _ := extractHostname(*urll)
+		browserJar, err := browserCookies(*cookiesFromBrowser, hostname)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error loading browser cookies:", err)
+			os.Exit(1)
+		}
+		cookies = append(cookies, browserJar...)
+	}
+
+	// URLs to start crawling from along with the main one
+	var seeds []string
+	if *fromHar != "" {
+		session, err := parseHAR(*fromHar)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing HAR file:", err)
+			os.Exit(1)
+		}
+		seeds = append(seeds, session.urls...)
+		cookies = append(cookies, session.cookies...)
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		// headers given with -h take precedence over the recording
+		for header, value := range session.headers {
+			if _, ok := headers[header]; !ok {
+				headers[header] = value
+			}
+		}
+	}
+	if *fromBurp != "" {
+		urls, err := parseBurpItems(*fromBurp)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing Burp export:", err)
+			os.Exit(1)
+		}
+		seeds = append(seeds, urls...)
+	}
+	// visited URLs of the crawl resumed, which are not requested again
+	var resumed map[string]bool
+	if *resumeFile != "" {
+		state, err := loadCheckpoint(*resumeFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading checkpoint:", err)
+			os.Exit(1)
+		}
+		seeds = append(seeds, state.Pending...)
+		if checkpoint != nil {
+			checkpoint.restore(state)
+		}
+		resumed = make(map[string]bool)
+		for _, u := range state.Visited {
+			resumed[u] = true
+		}
+	}
+	if *urll == "" && len(seeds) > 0 {
+		*urll = seeds[0]
+	}
+
+	if *headersFile != "" {
+		err = loadHostHeaders(*headersFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing headers file:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *parseJS {
+		*parserNames += ",js"
+	}
+	err = enableParsers(*parserNames)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error enabling parsers:", err)
+		os.Exit(1)
+	}
+
+	if *processorNames != "" {
+		err = enableProcessors(*processorNames)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error enabling processors:", err)
+			os.Exit(1)
+		}
+	}
+	if *scriptCmd != "" {
+		script, err := newScriptProcessor(*scriptCmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error starting script:", err)
+			os.Exit(1)
+		}
+		defer script.close()
+		activeProcessors = append(activeProcessors, script)
+	}
+
+	if *formProfile != "" {
+		err = loadFillProfile(*formProfile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing form profile:", err)
+			os.Exit(1)
+		}
+	}
+
+	var flow *loginFlow
+	if *loginFlowFile != "" {
+		flow, err = loadLoginFlow(*loginFlowFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing login flow:", err)
+			os.Exit(1)
+		}
+	}
+
+	var loggedOut *regexp.Regexp
+	if *sessionCheckURL != "" {
+		if *loggedOutRegex == "" {
+			fmt.Fprintln(os.Stderr, "-session-check-url requires -logged-out-regex")
+			os.Exit(1)
+		}
+		loggedOut, err = regexp.Compile(*loggedOutRegex)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing logged out regex:", err)
+			os.Exit(1)
+		}
+	}
+
+	// Compile the logout and dangerous action URL filters, and work out whether the crawl is authenticated at all
+	var logoutFilter *regexp.Regexp
+	if *logoutRegex != "" {
+		logoutFilter, err = regexp.Compile(*logoutRegex)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing logout regex:", err)
+			os.Exit(1)
+		}
+	}
+	var denyFilter *regexp.Regexp
+	if *denyRegex != "" {
+		denyFilter, err = regexp.Compile(*denyRegex)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing deny regex:", err)
+			os.Exit(1)
+		}
+	}
+	authenticated := *auth != "" || *ntlm != "" || *negotiateCmd != "" || *oauthTokenURL != "" || *tokenCmd != "" || flow != nil || len(cookies) > 0
+	for _, headers := range hostHeaders {
+		if hasCredentials(headers) {
+			authenticated = true
+		}
+	}
+
+	// Content types to download and crawl. Responses the enabled parsers extract links from
+	// are downloaded too.
+	crawlMimes := strings.Split(*visitMime, ",")
+
+	// Compile the response body filters
+	var bodyMatchers []*regexp.Regexp
+	if *matchString != "" {
+		bodyMatchers = append(bodyMatchers, regexp.MustCompile(regexp.QuoteMeta(*matchString)))
+	}
+	if *matchRegex != "" {
+		re, err := regexp.Compile(*matchRegex)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing match regex:", err)
+			os.Exit(1)
+		}
+		bodyMatchers = append(bodyMatchers, re)
+	}
+	if bodyMatchers != nil {
+		outputSources = map[string]bool{"match": true}
+	}
+
+	var scopeInclude, scopeExclude []*regexp.Regexp
+	if *scopeFile != "" {
+		scopeInclude, scopeExclude, err = loadScopeFile(*scopeFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing scope file:", err)
+			os.Exit(1)
+		}
+	}
+	if *burpScope != "" {
+		include, exclude, err := loadBurpScope(*burpScope)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing Burp scope:", err)
+			os.Exit(1)
+		}
+		scopeInclude = append(scopeInclude, include...)
+		scopeExclude = append(scopeExclude, exclude...)
+	}
+
+	if *forceHTTPS {
+		*schemePolicy = "https"
+	}
+	if *schemePolicy != "follow" && *schemePolicy != "forbid" && *schemePolicy != "https" {
+		fmt.Fprintln(os.Stderr, "Error: -scheme must be follow, forbid or https")
+		os.Exit(1)
+	}
+
+	var cidrs *cidrScope
+	if *scopeCIDR != "" {
+		// hosts are resolved like the crawl connects to them, with -host-map and -doh
+		cidrs, err = newCIDRScope(*scopeCIDR, &ipResolver{hosts: hosts, doh: resolver})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing scope CIDR:", err)
+			os.Exit(1)
+		}
+	}
+
+	var stopOn *regexp.Regexp
+	if *stopOnMatch != "" {
+		stopOn, err = regexp.Compile(*stopOnMatch)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing stop-on-match regex:", err)
+			os.Exit(1)
+		}
+	}
+
+	// Compile the extraction regexes
+	var extractors []*regexp.Regexp
+	for _, raw := range extractRegexes {
+		re, err := regexp.Compile(raw)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing extract regex:", err)
+			os.Exit(1)
+		}
+		extractors = append(extractors, re)
+	}
+	if extractors != nil && outputSources != nil {
+		outputSources["regex"] = true
+	}
+	if *reflectParams && outputSources != nil {
+		outputSources["reflection"] = true
+	}
+	if (includedHeaders != nil || requestTimings != nil) && !*showFinal && outputSources != nil {
+		outputSources["response"] = true
+	}
+	if *corsHeaders && outputSources != nil {
+		outputSources["cors"] = true
+	}
+	// oversize notices are always shown, as they point at resources that were not fully crawled
+	if outputSources != nil {
+		outputSources["oversize"] = true
+	}
+
+	// Open the traffic dump shared by all targets
+	var trafficFile *os.File
+	if *dumpTraffic != "" {
+		trafficFile, err = os.Create(*dumpTraffic)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error creating traffic dump:", err)
+			os.Exit(1)
+		}
+		defer trafficFile.Close()
+	}
+
+	// Render pages with headless Chrome, if anything needs it
+	var renderer *chromeRenderer
+	if *screenshotDir != "" || *domDir != "" || *captureXHR || *click {
+		for _, dir := range []string{*screenshotDir, *domDir} {
+			if dir == "" {
+				continue
+			}
+			err = os.MkdirAll(dir, 0755)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error creating output directory:", err)
+				os.Exit(1)
+			}
+		}
+		renderer, err = newChromeRenderer(*chromeBinary, *proxy, *insecure, *chromeNoSandbox, *threads)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error setting up headless Chrome:", err)
+			os.Exit(1)
+		}
+		renderer.screenshotDir = *screenshotDir
+		renderer.domDir = *domDir
+		renderer.xhr = *captureXHR
+		renderer.scrolls = *maxScrolls
+		renderer.idle = time.Duration(*idleTime) * time.Millisecond
+		if *click {
+			renderer.clicks = *maxClicks
+			renderer.clickTime = time.Duration(*clickTime) * time.Millisecond
+			renderer.clickSkip = denyFilter
+		}
+		if hosts != nil {
+			renderer.args = append(renderer.args, "--host-resolver-rules="+hosts.resolverRules())
+		}
+	}
+
+	// Check for stdin input
+	if *urll == "" {
+		stat, _ := os.Stdin.Stat()
+		if (stat.Mode() & os.ModeCharDevice) != 0 {
+			fmt.Fprintln(os.Stderr, "No urls detected. Hint: cat urls.txt | hakrawler")
+			os.Exit(1)
+		}
+	}
+	targets := make(chan crawlTarget)
+	go readTargets(*urll, targets)
+
+	results := make(chan string, *threads)
+	go func() {
+		for target := range targets {
+			url := target.URL
+			hostname, err := extractHostname(url)
+			if err != nil {
+				log.Println("Error parsing URL:", err)
+				continue
+			}
+
+			// the target's own headers take precedence over the global ones
+			targetHeaders := headers
+			if target.Headers != nil {
+				targetHeaders = make(map[string]string)
+				for header, value := range headers {
+					targetHeaders[header] = value
+				}
+				for header, value := range target.Headers {
+					targetHeaders[header] = value
+				}
+			}
+			targetDepth := *depth
+			if target.Depth > 0 {
+				targetDepth = target.Depth
+			}
+			include, err := compileRegexes(target.Include)
+			if err != nil {
+				log.Println("Error parsing include regex:", err)
+				continue
+			}
+			exclude, err := compileRegexes(target.Exclude)
+			if err != nil {
+				log.Println("Error parsing exclude regex:", err)
+				continue
+			}
+
+			allowed_domains := []string{hostname}
+			// if "Host" header is set, append it to allowed domains
+			if targetHeaders != nil {
+				if val, ok := targetHeaders["Host"]; ok {
+					allowed_domains = append(allowed_domains, val)
+				}
+			}
+
+			// Instantiate default collector
+			c := colly.NewCollector(
+				// default user agent header
+				colly.UserAgent("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/104.0.0.0 Safari/537.36"),
+				// set custom headers
+				colly.Headers(targetHeaders),
+				// limit crawling to the domain of the specified URL
+				colly.AllowedDomains(allowed_domains...),
+				// set MaxDepth to the specified depth
+				colly.MaxDepth(targetDepth),
+				// specify Async for threading
+				colly.Async(true),
+			)
+
+			// If `-respect-robots` flag provided, let colly check robots.txt before every request,
+			// for the user agent actually sent
+			if *respectRobots {
+				c.IgnoreRobotsTxt = false
+				if ua, ok := targetHeaders["User-Agent"]; ok {
+					c.UserAgent = ua
+				}
+			}
+
+			// set a page size limit, and report the pages that hit it
+			if *maxSize != -1 {
+				c.MaxBodySize = *maxSize * 1024
+				c.OnResponse(func(r *colly.Response) {
+					if len(r.Body) < c.MaxBodySize {
+						return
+					}
+					length, err := strconv.ParseInt(r.Headers.Get("Content-Length"), 10, 64)
+					if err != nil {
+						length = int64(len(r.Body))
+					}
+					sendResult(Result{Source: "oversize", URL: r.Request.URL.String(), ContentLength: length}, *showSource, *showJson, results)
+				})
+			}
+
+			// if -subs is present, use regex to filter out subdomains in scope.
+			if *subsInScope {
+				c.AllowedDomains = nil
+				c.URLFilters = []*regexp.Regexp{regexp.MustCompile(".*(\\.|\\/\\/)" + strings.ReplaceAll(hostname, ".", "\\.") + "((#|\\/|\\?).*)?")}
+				if excluded := subdomainFilter(*excludeSubs, hostname); excluded != nil {
+					c.DisallowedURLFilters = append(c.DisallowedURLFilters, excluded)
+				}
+			}
+
+			// the scope file and Burp scope replace the default scope
+			if scopeInclude != nil {
+				c.AllowedDomains = nil
+				c.URLFilters = append([]*regexp.Regexp{}, scopeInclude...)
+				c.DisallowedURLFilters = append(c.DisallowedURLFilters, scopeExclude...)
+			}
+
+			// apply the target's own scope regexes. colly lets in URLs matching any of its URL
+			// filters, so the includes, which narrow the scope down, are checked on top of them.
+			if include != nil {
+				c.OnRequest(func(r *colly.Request) {
+					if !inCollectorScope(r.URL, nil, include) {
+						abortRequest(r)
+					}
+				})
+			}
+			c.DisallowedURLFilters = append(c.DisallowedURLFilters, exclude...)
+
+			// the ports allowed with `-ports`, or nil for any
+			ports, err := parsePorts(*scopePorts, url)
+			if err != nil {
+				log.Println("Error parsing ports:", err)
+				continue
+			}
+
+			// If `-scheme` flag provided, keep to the target's scheme or upgrade to https, on
+			// the allowed ports
+			switch *schemePolicy {
+			case "forbid":
+				scheme := strings.SplitN(url, "://", 2)[0]
+				c.OnRequest(func(r *colly.Request) {
+					if !strings.EqualFold(r.URL.Scheme, scheme) {
+						abortRequest(r)
+					}
+				})
+			case "https":
+				// the collector saw the http URL, so the https ones are deduplicated here
+				var upgraded, originals, httpOnly sync.Map
+				c.OnRequest(func(r *colly.Request) {
+					if _, ok := httpOnly.Load(r.URL.Host); !ok && strings.EqualFold(r.URL.Scheme, "http") {
+						secure := *r.URL
+						upgradeToHTTPS(&secure)
+						if ports == nil || ports[effectivePort(&secure)] {
+							originals.Store(r.ID, r.URL.String())
+							*r.URL = secure
+						}
+					}
+					if r.Method != "GET" {
+						return
+					}
+					if _, seen := upgraded.LoadOrStore(r.URL.String(), true); seen {
+						abortRequest(r)
+					}
+				})
+				// fall back to http for hosts that do not speak https
+				c.OnError(func(r *colly.Response, err error) {
+					original, ok := originals.LoadAndDelete(r.Request.ID)
+					if !ok || r.StatusCode != 0 {
+						return
+					}
+					u, parseErr := r.Request.URL.Parse(original.(string))
+					if parseErr != nil {
+						return
+					}
+					httpOnly.Store(u.Host, true)
+					upgraded.Delete(r.Request.URL.String())
+					r.Request.URL = u
+					r.Request.Retry()
+				})
+			}
+
+			c.OnRequest(waitWhilePaused)
+
+			// If `-max-memory` flag provided, spill the links found while memory is short, and hold
+			// back the requests already queued
+			crawlFrontier = nil
+			if memory != nil {
+				crawlFrontier = newFrontier(memory, c)
+				c.OnRequest(memory.wait)
+			}
+			// wait waits until the crawl is done, with the requests the frontier holds back
+			wait := c.Wait
+			if crawlFrontier != nil {
+				wait = crawlFrontier.wait
+			}
+
+			// If `-ports` flag provided, keep to the allowed ports
+			if ports != nil {
+				c.OnRequest(func(r *colly.Request) {
+					if !ports[effectivePort(r.URL)] {
+						abortRequest(r)
+					}
+				})
+			}
+
+			// If `-scope-cidr` flag provided, also let in the hosts within the ranges. The collector
+			// cannot resolve hosts, so its scope is checked here instead, for requests and for the
+			// redirects they follow.
+			if cidrs != nil {
+				allowedDomains, urlFilters := c.AllowedDomains, c.URLFilters
+				c.AllowedDomains, c.URLFilters = nil, nil
+				c.OnRequest(func(r *colly.Request) {
+					if !cidrs.allows(r.URL, allowedDomains, urlFilters, ports) {
+						abortRequest(r)
+					}
+				})
+				// as colly does, but out of scope redirects are not followed
+				c.SetRedirectHandler(func(req *http.Request, via []*http.Request) error {
+					if !cidrs.allows(req.URL, allowedDomains, urlFilters, ports) {
+						return fmt.Errorf("not following redirect to %q: out of scope", req.URL)
+					}
+					if len(via) >= 10 {
+						return http.ErrUseLastResponse
+					}
+					if req.URL.Host != via[len(via)-1].URL.Host {
+						req.Header.Del("Authorization")
+					}
+					return nil
+				})
+			}
+
+			// do not log ourselves out, or change things, when crawling with credentials
+			if authenticated || hasCredentials(targetHeaders) {
+				if logoutFilter != nil {
+					c.DisallowedURLFilters = append(c.DisallowedURLFilters, logoutFilter)
+				}
+				if denyFilter != nil {
+					c.DisallowedURLFilters = append(c.DisallowedURLFilters, denyFilter)
+				}
+			}
+
+			// only download and parse responses of the allowed content types
+			if *visitMime != "" {
+				c.OnResponseHeaders(func(r *colly.Response) {
+					if _, ok := manifests.Load(r.Request.URL.String()); ok || (*favicon && isFavicon(r.Request.URL)) {
+						return
+					}
+					if !mimeAllowed(r.Headers.Get("Content-Type"), crawlMimes) && !parserWants(r) {
+						r.Request.Abort()
+					}
+				})
+			}
+
+			// If `-dr` flag provided, do not follow HTTP redirects.
+			if *disableRedirects {
+				c.SetRedirectHandler(func(req *http.Request, via []*http.Request) error {
+					return http.ErrUseLastResponse
+				})
+				// still print where redirects point. colly hands 3xx responses to OnError.
+				c.OnError(func(r *colly.Response, err error) {
+					if r.StatusCode < 300 || r.StatusCode >= 400 || r.Headers == nil {
+						return
+					}
+					if location := r.Headers.Get("Location"); location != "" {
+						sendResult(Result{Source: "location", URL: r.Request.AbsoluteURL(location), Status: r.StatusCode}, *showSource, *showJson, results)
+					}
+				})
+			}
+			// Set parallelism
+			c.Limit(&colly.LimitRule{DomainGlob: "*", Parallelism: *threads})
+
+			// prune crawler traps, unless `-keep-traps` flag provided
+			if !*keepTraps {
+				c.OnRequest(func(r *colly.Request) {
+					if reason := crawlerTrap(r.URL, time.Now()); reason != "" {
+						log.Println("[trap] " + reason + ": " + r.URL.String())
+						abortRequest(r)
+					}
+				})
+			}
+
+			// If `-pattern-budget` flag provided, stop visiting URLs of templates that used up their budget
+			if *patternBudgetLimit >= 0 {
+				c.OnRequest((&patternBudget{limit: *patternBudgetLimit}).check)
+			}
+
+			// If `-near-dup` flag provided, spot pages that are near-duplicates of crawled ones
+			var similar *simhashes
+			if *nearDup >= 0 {
+				similar = &simhashes{maxDistance: *nearDup}
+				c.OnResponse(similar.check)
+				c.OnScraped(similar.forget)
+			}
+
+			// the transport answering Basic challenges with the `-auth` credentials, set up below
+			var basicAuth *basicAuthTransport
+
+			// If `-screenshot`, `-dom-snapshot`, `-xhr` or `-click` flag provided, render every
+			// crawled page once for all of them, with the crawl's headers, credentials and
+			// cookies, and output what it turned up
+			if renderer != nil {
+				c.OnHTML("html", func(e *colly.HTMLElement) {
+					headers := make(http.Header)
+					for header, value := range targetHeaders {
+						headers.Set(header, value)
+					}
+					for header, value := range headersForHost(e.Request.URL.Hostname()) {
+						headers.Set(header, value)
+					}
+					if basicAuth != nil && headers.Get("Authorization") == "" {
+						if authorization := basicAuth.authorization(e.Request.URL); authorization != "" {
+							headers.Set("Authorization", authorization)
+						}
+					}
+					rendered, err := renderer.capture(e.Request.URL.String(), headers, c.Cookies(e.Request.URL.String()))
+					if err != nil {
+						log.Println("Error rendering "+e.Request.URL.String()+":", err)
+					}
+					for _, req := range rendered.requests {
+						sendResult(Result{Source: "xhr", URL: req.URL, Method: req.Method, BodyType: req.ContentType, BodyParams: req.Params}, *showSource, *showJson, results)
+					}
+					for _, u := range rendered.navigations {
+						sendResult(Result{Source: "click", URL: u}, *showSource, *showJson, results)
+						queueVisit(e.Request, u)
+					}
+					for _, req := range rendered.clickRequests {
+						sendResult(Result{Source: "click-xhr", URL: req.URL, Method: req.Method, BodyType: req.ContentType, BodyParams: req.Params}, *showSource, *showJson, results)
+					}
+				})
+			}
+
+			// output and visit the links found by the enabled parsers
+			emitLinks := func(r *colly.Request, links []ParsedLink) {
+				for _, link := range links {
+					if link.Source != "" {
+						sendResult(Result{Source: link.Source, URL: link.URL}, *showSource, *showJson, results)
+					}
+					if link.Visit {
+						queueVisit(r, link.URL)
+					}
+				}
+			}
+			parserOrder := make([]string, 0, len(activeParsers))
+			for name := range activeParsers {
+				parserOrder = append(parserOrder, name)
+			}
+			sort.Strings(parserOrder)
+			for _, name := range parserOrder {
+				p := activeParsers[name]
+				c.OnResponse(func(r *colly.Response) {
+					if p.Handles(r) {
+						emitLinks(r.Request, p.Parse(r))
+					}
+				})
+			}
+
+			// the links of pages are extracted by OnHTML callbacks, registered with onHTML so the
+			// html parser can be disabled
+			onHTML := func(selector string, f colly.HTMLCallback) {
+				if parseHTML {
+					c.OnHTML(selector, f)
+				}
+			}
+
+			// Print every href found, and visit it
+			onHTML("a[href]", func(e *colly.HTMLElement) {
+				link := e.Attr("href")
+				// links of near-duplicate pages, and pseudo-URLs, are printed but not followed
+				if pseudoScheme(link) != "" || similar.isDuplicate(e.Response) {
+					printResult(link, "href", *showSource, *showJson, results, e)
+					return
+				}
+				if *showFinal {
+					// hold the result back until the response tells us where the link ends up
+					absolute := e.Request.AbsoluteURL(link)
+					pending.Store(absolute, "href")
+					if queueVisit(e.Request, link) != nil {
+						pending.Delete(absolute)
+						sendResult(Result{Source: "href", URL: absolute}, *showSource, *showJson, results)
+					}
+					return
+				}
+				printResult(link, "href", *showSource, *showJson, results, e)
+				queueVisit(e.Request, link)
+			})
+
+			// remember the <base href> of pages for the scripts found on them. colly resolves the
+			// links of the page's elements against it already.
+			onHTML("base[href]", func(e *colly.HTMLElement) {
+				pageBases.LoadOrStore(e.Request.URL.String(), e.Request.AbsoluteURL(e.Attr("href")))
+			})
+
+			// find and print all the JavaScript files
+			onHTML("script[src]", func(e *colly.HTMLElement) {
+				printResult(e.Attr("src"), "script", *showSource, *showJson, results, e)
+				if base := loadBase(&pageBases, e.Request.URL.String()); base != "" {
+					scriptBases.LoadOrStore(e.Request.AbsoluteURL(e.Attr("src")), base)
+				}
+				if activeParsers["js"] != nil {
+					queueVisit(e.Request, e.Attr("src"))
+				}
+			})
+
+			// If the js parser is enabled, extract links from inline scripts too
+			if activeParsers["js"] != nil {
+				onHTML("script:not([src])", func(e *colly.HTMLElement) {
+					emitLinks(e.Request, jsLinks(e.Text, e.Request, loadBase(&pageBases, e.Request.URL.String())))
+				})
+			}
+
+			// print and visit the paths cookies are scoped to, which are often where applications
+			// are mounted, e.g. /portal
+			var cookiePaths sync.Map
+			c.OnResponse(func(r *colly.Response) {
+				for _, cookie := range (&http.Response{Header: *r.Headers}).Cookies() {
+					if cookie.Path == "" || cookie.Path == "/" || !strings.HasPrefix(cookie.Path, "/") {
+						continue
+					}
+					link := r.Request.URL.Scheme + "://" + r.Request.URL.Host + cookie.Path
+					if _, seen := cookiePaths.LoadOrStore(link, true); !seen {
+						sendResult(Result{Source: "cookie", URL: link}, *showSource, *showJson, results)
+						queueVisit(r.Request, link)
+					}
+				}
+			})
+
+			// print and visit the links of Link headers, e.g. preloaded scripts, alternates, API
+			// descriptions and WebSub hubs. Connection hints only name origins, and are not visited.
+			c.OnResponse(func(r *colly.Response) {
+				for _, link := range linkHeaderLinks(r.Headers.Values("Link")) {
+					sendResult(Result{Source: "link", URL: r.Request.AbsoluteURL(link.URL)}, *showSource, *showJson, results)
+					hint := false
+					for _, rel := range link.Rels {
+						hint = hint || rel == "preconnect" || rel == "dns-prefetch"
+					}
+					if !hint {
+						queueVisit(r.Request, link.URL)
+					}
+				}
+			})
+
+			// print the hosts and report endpoints of Content-Security-Policy headers and <meta> tags,
+			// once each, as sites repeat the same policy on every page. They are not visited.
+			var cspSeen sync.Map
+			emitCSP := func(r *colly.Request, policies []string) {
+				for _, source := range cspSources(policies, r.URL.Scheme) {
+					link := r.AbsoluteURL(source)
+					if _, seen := cspSeen.LoadOrStore(link, true); !seen && link != "" {
+						sendResult(Result{Source: "csp", URL: link}, *showSource, *showJson, results)
+					}
+				}
+			}
+			c.OnResponse(func(r *colly.Response) {
+				emitCSP(r.Request, append(r.Headers.Values("Content-Security-Policy"), r.Headers.Values("Content-Security-Policy-Report-Only")...))
+			})
+			onHTML("meta[http-equiv][content]", func(e *colly.HTMLElement) {
+				if strings.EqualFold(e.Attr("http-equiv"), "Content-Security-Policy") {
+					emitCSP(e.Request, []string{e.Attr("content")})
+				}
+			})
+
+			// find and print web app manifests, and fetch them to print the URLs they list
+			onHTML("link[rel=manifest][href]", func(e *colly.HTMLElement) {
+				printResult(e.Attr("href"), "manifest", *showSource, *showJson, results, e)
+				manifests.Store(e.Request.AbsoluteURL(e.Attr("href")), true)
+				queueVisit(e.Request, e.Attr("href"))
+			})
+			c.OnResponse(func(r *colly.Response) {
+				if _, ok := manifests.Load(r.Request.URL.String()); !ok {
+					return
+				}
+				urls, err := manifestURLs(r.Body)
+				if err != nil {
+					return
+				}
+				for _, u := range urls {
+					sendResult(Result{Source: "manifest", URL: r.Request.AbsoluteURL(u)}, *showSource, *showJson, results)
+				}
+			})
+
+			// find, print and visit the language variants and the next and previous pages of a page
+			onHTML("link[rel~=alternate][href]", func(e *colly.HTMLElement) {
+				printResult(e.Attr("href"), "alternate", *showSource, *showJson, results, e)
+				queueVisit(e.Request, e.Attr("href"))
+			})
+			onHTML("link[rel~=next][href], link[rel~=prev][href], link[rel~=previous][href]", func(e *colly.HTMLElement) {
+				printResult(e.Attr("href"), "pagination", *showSource, *showJson, results, e)
+				queueVisit(e.Request, e.Attr("href"))
+			})
+
+			// find and print all the form action URLs, with the parameters of GET forms
+			onHTML("form[action]", func(e *colly.HTMLElement) {
+				printResult(formQueryURL(e), "form", *showSource, *showJson, results, e)
+			})
+
+			// print the action of forms that take file uploads, which default to the page itself
+			onHTML("form", func(e *colly.HTMLElement) {
+				upload := strings.EqualFold(strings.TrimSpace(e.Attr("enctype")), "multipart/form-data")
+				e.ForEach("input[type=file]", func(_ int, _ *colly.HTMLElement) {
+					upload = true
+				})
+				if !upload {
+					return
+				}
+				action := e.Attr("action")
+				if action == "" {
+					action = e.Request.URL.String()
+				}
+				printResult(action, "upload", *showSource, *showJson, results, e)
+			})
+
+			// find and print the resources of plugins: objects, embeds and applets, with their params
+			onHTML("object[data]", func(e *colly.HTMLElement) {
+				printResult(e.Attr("data"), "object", *showSource, *showJson, results, e)
+			})
+			onHTML("embed[src]", func(e *colly.HTMLElement) {
+				printResult(e.Attr("src"), "embed", *showSource, *showJson, results, e)
+			})
+			onHTML("object, applet", func(e *colly.HTMLElement) {
+				e.ForEach("param[value]", func(_ int, param *colly.HTMLElement) {
+					if urlParams[strings.ToLower(param.Attr("name"))] || looksLikeURL(param.Attr("value")) {
+						printResult(param.Attr("value"), "param", *showSource, *showJson, results, e)
+					}
+				})
+			})
+			onHTML("applet", func(e *colly.HTMLElement) {
+				codebase := e.Request.AbsoluteURL(e.Attr("codebase"))
+				for _, file := range appletFiles(e.Attr("code"), e.Attr("archive")) {
+					if link := resolveAgainst(e.Request, codebase, file); link != "" {
+						printResult(link, "applet", *showSource, *showJson, results, e)
+					}
+				}
+			})
+
+			// find and print the URLs of data attributes, which lazy-loading scripts fetch or navigate to
+			onHTML("[data-url], [data-src], [data-href]", func(e *colly.HTMLElement) {
+				for _, attr := range []string{"data-url", "data-src", "data-href"} {
+					if link := strings.TrimSpace(e.Attr(attr)); link != "" {
+						printResult(link, attr, *showSource, *showJson, results, e)
+					}
+				}
+				// only links are followed, data-src being mostly images
+				if link := strings.TrimSpace(e.Attr("data-href")); link != "" && pseudoScheme(link) == "" {
+					queueVisit(e.Request, link)
+				}
+			})
+
+			// If `-submit-forms` flag provided, submit forms to discover what is behind them
+			if *formSubmit {
+				c.OnHTML("html", func(e *colly.HTMLElement) {
+					if !similar.isDuplicate(e.Response) {
+						submitForms(e)
+					}
+				})
+			}
+
+			// run the enabled processors
+			for _, p := range activeProcessors {
+				p := p
+				c.OnRequest(p.OnRequest)
+				c.OnResponse(func(r *colly.Response) {
+					for _, res := range p.OnResponse(r) {
+						sendResult(res, *showSource, *showJson, results)
+					}
+				})
+			}
+
+			// add the custom headers
+			if targetHeaders != nil {
+				c.OnRequest(func(r *colly.Request) {
+					for header, value := range targetHeaders {
+						r.Headers.Set(header, value)
+					}
+				})
+			}
+
+			// add the custom headers for the request's host
+			if hostHeaders != nil {
+				c.OnRequest(func(r *colly.Request) {
+					for header, value := range headersForHost(r.URL.Hostname()) {
+						r.Headers.Set(header, value)
+					}
+				})
+			}
+
+			if *formSubmit {
+				c.OnRequest(addCSRFHeaders)
+			}
+
+			if *showFinal {
+				c.OnRequest(func(r *colly.Request) {
+					origins.Store(r.ID, r.URL.String())
+				})
+				// by now r.Request.URL has been updated to the end of the redirect chain
+				c.OnResponse(func(r *colly.Response) {
+					flushPending(r, *showSource, *showJson, results)
+				})
+				c.OnError(func(r *colly.Response, err error) {
+					flushPending(r, *showSource, *showJson, results)
+				})
+			} else if includedHeaders != nil || requestTimings != nil {
+				// output a result for every visited URL to carry its headers and timing
+				visited := func(r *colly.Response) {
+					if r.Headers == nil {
+						return
+					}
+					res := Result{Source: "response", URL: r.Request.URL.String(), Status: r.StatusCode, ContentType: r.Headers.Get("Content-Type"), Headers: selectHeaders(r.Headers)}
+					setTiming(&res, r)
+					sendResult(res, *showSource, *showJson, results)
+				}
+				c.OnResponse(visited)
+				c.OnError(func(r *colly.Response, err error) {
+					visited(r)
+				})
+			}
+
+			// output the visited pages whose body matches any of the filters
+			if bodyMatchers != nil {
+				c.OnResponse(func(r *colly.Response) {
+					for _, re := range bodyMatchers {
+						if re.Match(r.Body) {
+							sendResult(Result{Source: "match", URL: r.Request.URL.String(), Status: r.StatusCode, ContentType: r.Headers.Get("Content-Type"), Headers: selectHeaders(r.Headers)}, *showSource, *showJson, results)
+							return
+						}
+					}
+				})
+			}
+
+			// output every match of the extraction regexes
+			if extractors != nil {
+				c.OnResponse(func(r *colly.Response) {
+					for _, re := range extractors {
+						for _, res := range extractMatches(re, r.Body) {
+							res.URL = r.Request.URL.String()
+							res.Status = r.StatusCode
+							res.ContentType = r.Headers.Get("Content-Type")
+							res.Headers = selectHeaders(r.Headers)
+							sendResult(res, *showSource, *showJson, results)
+						}
+					}
+				})
+			}
+
+			var transport *http.Transport
+			if routes != nil {
+				transport = &http.Transport{
+					Proxy:                 routes.proxy,
+					GetProxyConnectHeader: routes.connectHeader,
+					TLSClientConfig:       &tls.Config{InsecureSkipVerify: *insecure},
+				}
+			} else if *proxy != "" {
+				// Skip TLS verification for proxy, if -insecure specified
+				transport = &http.Transport{
+					Proxy:              http.ProxyURL(proxyURL),
+					ProxyConnectHeader: proxyHeaders,
+					TLSClientConfig:    &tls.Config{InsecureSkipVerify: *insecure},
+				}
+			} else {
+				// Skip TLS verification if -insecure flag is present
+				transport = &http.Transport{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure},
+				}
+			}
+			// If `-doh` flag provided, resolve hostnames with DNS over HTTPS
+			if resolver != nil {
+				transport.DialContext = resolver.dialContext
+			}
+
+			// If `-host-map` flag provided, connect to the pinned IPs of mapped hostnames
+			if hosts != nil {
+				transport.DialContext = hosts.dialContext(transport.DialContext)
+			}
+
+			// If `-proxy-file` flag provided, connect to the HTTPS proxies with their own TLS settings
+			if routes != nil {
+				transport.DialContext = routes.dialContext(transport.DialContext)
+			}
+			var roundTripper http.RoundTripper = transport
+
+			// If `-timing` flag provided, time the requests as close to the network as possible
+			if requestTimings != nil {
+				roundTripper = requestTimings.wrap(roundTripper)
+			}
 
-func main() {
-	urll := flag.String(("u"), "", "the url to crawl")
-	threads := flag.Int("t", 8, "Number of threads to utilise.")
-	depth := flag.Int("d", 2, "Depth to crawl.")
-	maxSize := flag.Int("size", -1, "Page size limit, in KB.")
-	insecure := flag.Bool("insecure", false, "Disable TLS verification.")
-	subsInScope := flag.Bool("subs", false, "Include subdomains for crawling.")
-	showJson := flag.Bool("json", false, "Output as JSON.")
-	showSource := flag.Bool("s", false, "Show the source of URL based on where it was found. E.g. href, form, script, etc.")
-	rawHeaders := flag.String(("h"), "", "Custom headers separated by two semi-colons. E.g. -h \"Cookie: foo=bar;;Referer: http://example.com/\" ")
-	unique := flag.Bool(("unique"), false, "Show only unique urls.")
-	proxy := flag.String(("proxy"), "", "Proxy URL. E.g. -proxy http://127.0.0.1:8080")
-	timeout := flag.Int("timeout", -1, "Maximum time to crawl each URL from stdin, in seconds.")
-	disableRedirects := flag.Bool("dr", false, "Disable following HTTP redirects.")
+			// If `-pprof`, `-metrics` or `-summary` flag provided, count the requests sent
+			if stats != nil {
+				roundTripper = stats.wrap(roundTripper)
+			}
 
-	flag.Parse()
+			// If `-otlp-endpoint` flag provided, trace the requests sent
+			if tracer != nil {
+				roundTripper = tracer.wrap(roundTripper)
+			}
 
-	if *proxy != "" {
-		os.Setenv("PROXY", *proxy)
-	}
-	proxyURL, _ := url.Parse(os.Getenv("PROXY"))
+			// If `-control-file` flag provided, apply its rate settings, all targets together
+			if control != nil {
+				roundTripper = control.wrap(roundTripper)
+			}
 
-	// Convert the headers input to a usable map (or die trying)
-	err := parseHeaders(*rawHeaders)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error parsing headers:", err)
-		os.Exit(1)
-	}
+			// If `-respect-robots` flag provided, wait the Crawl-delay of robots.txt between
+			// requests to a host, all targets together
+			var robotsTransport *crawlDelayTransport
+			if robotsDelays != nil {
+				robotsTransport = robotsDelays.wrap(roundTripper)
+				roundTripper = robotsTransport
+			}
 
-	// Check for stdin input
-	// stat, _ := os.Stdin.Stat()
-	// if (stat.Mode() & os.ModeCharDevice) != 0 {
-	// 	fmt.Fprintln(os.Stderr, "No urls detected. Hint: cat urls.txt | hakrawler")
-	// 	os.Exit(1)
-	// }
-	// var url *string
-	results := make(chan string, *threads)
-	go func() {
-		// get each line of stdin, push it to the work channel
-		// s := bufio.NewScanner(os.Stdin)
-		// for s.Scan() {
-		// url := s.Text()
-		url := *urll
-		hostname, err := extractHostname(url)
-		if err != nil {
-			log.Println("Error parsing URL:", err)
-			return
-		}
+			// If `-max-bandwidth` flag provided, slow down downloads, all targets together
+			if limiter != nil {
+				roundTripper = limiter.wrap(roundTripper)
+			}
 
-		allowed_domains := []string{hostname}
-		// if "Host" header is set, append it to allowed domains
-		if headers != nil {
-			if val, ok := headers["Host"]; ok {
-				allowed_domains = append(allowed_domains, val)
+			// If `-dump-traffic` flag provided, record the requests as they are sent
+			if trafficFile != nil {
+				roundTripper = newTrafficDumper(roundTripper, trafficFile)
 			}
-		}
 
-		// Instantiate default collector
-		c := colly.NewCollector(
-			// default user agent header
-			colly.UserAgent("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/104.0.0.0 Safari/537.36"),
-			// set custom headers
-			colly.Headers(headers),
-			// limit crawling to the domain of the specified URL
-			colly.AllowedDomains(allowed_domains...),
-			// set MaxDepth to the specified depth
-			colly.MaxDepth(*depth),
-			// specify Async for threading
-			colly.Async(true),
-		)
+			// If `-auth` flag provided, answer Digest and Basic challenges
+			if *auth != "" {
+				roundTripper, err = newDigestAuthTransport(roundTripper, *auth)
+				if err != nil {
+					log.Println("Error parsing credentials:", err)
+					continue
+				}
+				basicAuth, err = newBasicAuthTransport(roundTripper, *auth)
+				if err != nil {
+					log.Println("Error parsing credentials:", err)
+					continue
+				}
+				roundTripper = basicAuth
+			}
 
-		// set a page size limit
-		if *maxSize != -1 {
-			c.MaxBodySize = *maxSize * 1024
-		}
+			// If `-ntlm` flag provided, answer NTLM challenges
+			if *ntlm != "" {
+				roundTripper, err = newNTLMTransport(roundTripper, *ntlm)
+				if err != nil {
+					log.Println("Error parsing NTLM credentials:", err)
+					continue
+				}
+			}
 
-		// if -subs is present, use regex to filter out subdomains in scope.
-		if *subsInScope {
-			c.AllowedDomains = nil
-			c.URLFilters = []*regexp.Regexp{regexp.MustCompile(".*(\\.|\\/\\/)" + strings.ReplaceAll(hostname, ".", "\\.") + "((#|\\/|\\?).*)?")}
-		}
+			// If `-negotiate-cmd` flag provided, answer Negotiate challenges with its tokens
+			if *negotiateCmd != "" {
+				roundTripper, err = newNegotiateTransport(roundTripper, *negotiateCmd)
+				if err != nil {
+					log.Println("Error parsing Negotiate token command:", err)
+					continue
+				}
+			}
 
-		// If `-dr` flag provided, do not follow HTTP redirects.
-		if *disableRedirects {
-			c.SetRedirectHandler(func(req *http.Request, via []*http.Request) error {
-				return http.ErrUseLastResponse
-			})
-		}
-		// Set parallelism
-		c.Limit(&colly.LimitRule{DomainGlob: "*", Parallelism: *threads})
-
-		// Print every href found, and visit it
-		c.OnHTML("a[href]", func(e *colly.HTMLElement) {
-			link := e.Attr("href")
-			printResult(link, "href", *showSource, *showJson, results, e)
-			e.Request.Visit(link)
-		})
-
-		// find and print all the JavaScript files
-		c.OnHTML("script[src]", func(e *colly.HTMLElement) {
-			printResult(e.Attr("src"), "script", *showSource, *showJson, results, e)
-		})
-
-		// find and print all the form action URLs
-		c.OnHTML("form[action]", func(e *colly.HTMLElement) {
-			printResult(e.Attr("action"), "form", *showSource, *showJson, results, e)
-		})
-
-		// add the custom headers
-		if headers != nil {
-			c.OnRequest(func(r *colly.Request) {
-				for header, value := range headers {
-					r.Headers.Set(header, value)
+			// If `-oauth-token-url` flag provided, send a bearer token from the client credentials grant
+			if *oauthTokenURL != "" {
+				oauth := &oauthTransport{
+					next:         roundTripper,
+					tokenURL:     *oauthTokenURL,
+					clientID:     *oauthClientID,
+					clientSecret: *oauthClientSecret,
+					scope:        *oauthScope,
 				}
-			})
-		}
+				if _, err := oauth.token(); err != nil {
+					log.Println("Error getting OAuth2 token:", err)
+					continue
+				}
+				roundTripper = oauth
+			}
 
-		if *proxy != "" {
-			// Skip TLS verification for proxy, if -insecure specified
-			c.WithTransport(&http.Transport{
-				Proxy:           http.ProxyURL(proxyURL),
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure},
-			})
-		} else {
-			// Skip TLS verification if -insecure flag is present
-			c.WithTransport(&http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure},
-			})
-		}
+			// If a bearer JWT or `-token-cmd` flag is provided, keep the token fresh
+			var bearer string
+			if strings.HasPrefix(headers["Authorization"], "Bearer ") {
+				bearer = strings.TrimPrefix(headers["Authorization"], "Bearer ")
+			}
+			// relogin restores a lost session, if there is a way to
+			var relogin func() error
+			if *tokenCmd != "" || !jwtExpiry(bearer).IsZero() {
+				jwt, err := newJWTTransport(roundTripper, bearer, *tokenCmd)
+				if err != nil {
+					log.Println("Error getting token:", err)
+					continue
+				}
+				roundTripper = jwt
+				if *tokenCmd != "" {
+					relogin = jwt.forceRefresh
+				}
+			}
+			// If `-timing` flag provided, pass the requests' IDs on to the timing RoundTripper
+			if requestTimings != nil {
+				roundTripper = requestTimings.untag(roundTripper)
+			}
+			// If `-pprof`, `-metrics` or `-summary` flag provided, count the queued requests out as they are sent
+			if stats != nil {
+				roundTripper = stats.dequeue(roundTripper)
+			}
+			c.WithTransport(roundTripper)
+			// fetch robots.txt for its Crawl-delay like a page, with the proxy, credentials and cookies
+			if robotsTransport != nil {
+				robotsTransport.fetch = roundTripper
+				robotsTransport.cookies = c.Cookies
+			}
+
+			// fill the cookie jar, now that the transport is in place
+			for _, jc := range cookies {
+				c.SetCookies(jc.url, []*http.Cookie{jc.cookie})
+			}
+
+			// If `-head-first` flag provided, check what unknown resources are before downloading them
+			if *headFirst {
+				client := &http.Client{Transport: roundTripper}
+				c.OnRequest(func(r *colly.Request) {
+					if r.Method != "GET" || isPageExtension(r.URL.Path) {
+						return
+					}
+					if _, ok := manifests.Load(r.URL.String()); ok || (*favicon && isFavicon(r.URL)) {
+						return
+					}
+					req, err := http.NewRequest("HEAD", r.URL.String(), nil)
+					if err != nil {
+						return
+					}
+					req.Header = r.Headers.Clone()
+					resp, err := client.Do(req)
+					if err != nil {
+						return
+					}
+					resp.Body.Close()
+					if *visitMime != "" && !mimeAllowed(resp.Header.Get("Content-Type"), crawlMimes) && !parserWants(&colly.Response{Request: r, Headers: &resp.Header}) {
+						abortRequest(r)
+					} else if *maxSize != -1 && resp.ContentLength > int64(*maxSize*1024) {
+						abortRequest(r)
+						sendResult(Result{Source: "oversize", URL: r.URL.String(), ContentLength: resp.ContentLength}, *showSource, *showJson, results)
+					}
+				})
+			}
+
+			// If `-reflect` flag provided, probe the query parameters of crawled pages for reflection
+			if *reflectParams {
+				client := &http.Client{Transport: roundTripper}
+				c.OnResponse(func(r *colly.Response) {
+					if r.Request.Method != "GET" || r.Request.URL.RawQuery == "" {
+						return
+					}
+					for _, res := range probeReflection(client, c, r.Request) {
+						sendResult(res, *showSource, *showJson, results)
+					}
+				})
+			}
+
+			// If `-cors` flag provided, output the CORS policy of every response. Responses with
+			// error statuses are handed to OnError, and often carry the headers too.
+			if *corsHeaders {
+				corsPolicy := func(r *colly.Response) {
+					if r.Headers == nil || r.Headers.Get("Access-Control-Allow-Origin") == "" {
+						return
+					}
+					sendResult(Result{
+						Source:           "cors",
+						URL:              r.Request.URL.String(),
+						Status:           r.StatusCode,
+						AllowOrigin:      r.Headers.Get("Access-Control-Allow-Origin"),
+						AllowCredentials: strings.EqualFold(strings.TrimSpace(r.Headers.Get("Access-Control-Allow-Credentials")), "true"),
+					}, *showSource, *showJson, results)
+				}
+				c.OnResponse(corsPolicy)
+				c.OnError(func(r *colly.Response, err error) {
+					corsPolicy(r)
+				})
+			}
+
+			// If `-header-audit` flag provided, record the security headers of crawled pages
+			if audit != nil {
+				c.OnResponse(audit.check)
+			}
+
+			// If `-favicon` flag provided, hash the favicon of every host crawled, once across targets
+			if *favicon {
+				c.OnResponse(func(r *colly.Response) {
+					if isFavicon(r.Request.URL) {
+						if icon, ok := faviconBody(r); ok {
+							stats.favicon(r.Request.URL.Hostname(), faviconHash(icon))
+						}
+						return
+					}
+					// queued like any other request, with the crawl's headers and cookies
+					if _, seen := faviconHosts.LoadOrStore(r.Request.URL.Hostname(), true); !seen {
+						c.Visit(r.Request.URL.Scheme + "://" + r.Request.URL.Host + "/favicon.ico")
+					}
+				})
+			}
+
+			// If `-login-flow` flag provided, log in before crawling
+			if flow != nil {
+				if err := flow.run(roundTripper, c); err != nil {
+					log.Println("Error running login flow:", err)
+					continue
+				}
+				c.OnRequest(flow.apply)
+				relogin = func() error {
+					return flow.run(roundTripper, c)
+				}
+			}
+
+			// If `-session-check-url` flag provided, keep an eye on the session during the crawl
+			stop := make(chan struct{})
+			if *sessionCheckURL != "" {
+				watchdog := &sessionWatchdog{
+					checkURL:  *sessionCheckURL,
+					loggedOut: loggedOut,
+					interval:  time.Duration(*sessionCheckInterval) * time.Second,
+					client:    &http.Client{Transport: roundTripper},
+					collector: c,
+					relogin:   relogin,
+					flow:      flow,
+				}
+				c.OnRequest(watchdog.wait)
+				go watchdog.run(stop)
+			}
+
+			// If `-stop-on-match` flag provided, stop crawling the target at the first match
+			if stopOn != nil {
+				var matched int32
+				c.OnRequest(func(r *colly.Request) {
+					if atomic.LoadInt32(&matched) == 1 {
+						abortRequest(r)
+					} else if stopOn.MatchString(r.URL.String()) && atomic.CompareAndSwapInt32(&matched, 0, 1) {
+						sendResult(Result{Source: "match", URL: r.URL.String()}, *showSource, *showJson, results)
+						abortRequest(r)
+					}
+				})
+				c.OnResponse(func(r *colly.Response) {
+					if stopOn.Match(r.Body) && atomic.CompareAndSwapInt32(&matched, 0, 1) {
+						sendResult(Result{Source: "match", URL: r.Request.URL.String(), Status: r.StatusCode, ContentType: r.Headers.Get("Content-Type"), Headers: selectHeaders(r.Headers)}, *showSource, *showJson, results)
+					}
+				})
+			}
+
+			// If `-error-log` flag provided, record the requests that failed
+			if failures != nil {
+				c.OnError(failures.record)
+			}
+
+			// If `-exclude-urls` flag provided, only crawl the URLs not known yet. The targets
+			// themselves are still crawled, or there would be nothing to start from.
+			if excludedURLs != nil {
+				c.OnRequest(func(r *colly.Request) {
+					if r.Depth > 1 && excludedURLs[r.URL.String()] {
+						abortRequest(r)
+					}
+				})
+			}
+
+			// If `-resume` flag provided, skip the URLs visited before the crawl was interrupted
+			if resumed != nil {
+				c.OnRequest(func(r *colly.Request) {
+					if resumed[r.URL.String()] {
+						abortRequest(r)
+					}
+				})
+			}
+
+			// If `-checkpoint` flag provided, keep track of the URLs visited and pending
+			if checkpoint != nil {
+				checkpoint.track(c)
+			}
 
-		if *timeout == -1 {
-			// Start scraping
-			c.Visit(url)
-			// Wait until threads are finished
-			c.Wait()
-		} else {
-			finished := make(chan int, 1)
+			// If `-max-results` flag provided, stop crawling the target once enough results are out
+			if *maxResults > 0 {
+				atomic.StoreInt64(&resultLimit, atomic.LoadInt64(&resultsSent)+int64(*maxResults))
+				c.OnRequest(func(r *colly.Request) {
+					if atomic.LoadInt64(&resultsSent) >= atomic.LoadInt64(&resultLimit) {
+						abortRequest(r)
+					}
+				})
+			}
+
+			// If `-timing` flag provided, tag the requests to time, once every other callback is set
+			if requestTimings != nil {
+				requestTimings.track(c)
+			}
+			// If `-pprof`, `-metrics` or `-summary` flag provided, count the requests queued
+			if stats != nil {
+				stats.track(c)
+			}
+
+			// the first request follows the -request template, if any, then come the extra seeds
+			visit := func() {
+				if requestTemplate != nil {
+					c.Request(requestTemplate.Method, url, bytes.NewReader(templateBody), nil, nil)
+				} else {
+					c.Visit(url)
+				}
+				// out of scope seeds are filtered out by the collector
+				for _, seed := range seeds {
+					c.Visit(seed)
+				}
+			}
 
-			go func() {
+			// If `-retry-failed` flag provided, collect the transient failures for a second pass
+			var failedMu sync.Mutex
+			var failed []*colly.Request
+			secondPass := false
+			if *retryFailed {
+				c.OnError(func(r *colly.Response, err error) {
+					failedMu.Lock()
+					defer failedMu.Unlock()
+					if !secondPass && transientFailure(r, err) {
+						failed = append(failed, r.Request)
+					}
+				})
+			}
+			retry := func() {
+				failedMu.Lock()
+				requests := failed
+				secondPass = true
+				failedMu.Unlock()
+				for _, r := range requests {
+					time.Sleep(time.Second)
+					r.Retry()
+					c.Wait()
+				}
+			}
+
+			if *timeout == -1 {
 				// Start scraping
-				c.Visit(url)
+				visit()
 				// Wait until threads are finished
-				c.Wait()
-				finished <- 0
-			}()
+				wait()
+				retry()
+			} else {
+				finished := make(chan int, 1)
+
+				go func() {
+					// Start scraping
+					visit()
+					// Wait until threads are finished
+					wait()
+					retry()
+					finished <- 0
+				}()
 
-			select {
-			case _ = <-finished: // the crawling finished before the timeout
-				close(finished)
-				// continue
-			case <-time.After(time.Duration(*timeout) * time.Second): // timeout reached
-				log.Println("[timeout] " + url)
-				// continue
+				select {
+				case _ = <-finished: // the crawling finished before the timeout
+					close(finished)
+					// continue
+				case <-time.After(time.Duration(*timeout) * time.Second): // timeout reached
+					log.Println("[timeout] " + url)
+					// continue
 
+				}
 			}
-		}
 
-		// }
-		// if err := s.Err(); err != nil {
-		// 	fmt.Fprintln(os.Stderr, "reading standard input:", err)
-		// }
+			close(stop)
+			if crawlFrontier != nil {
+				crawlFrontier.close()
+			}
+
+			// print whatever -show-final is still holding back as-is
+			pending.Range(func(key, value interface{}) bool {
+				pending.Delete(key)
+				sendResult(Result{Source: value.(string), URL: key.(string)}, *showSource, *showJson, results)
+				return true
+			})
+
+		}
 		close(results)
 	}()
 
-	w := bufio.NewWriter(os.Stdout)
+	w := bufio.NewWriter(out)
 	defer w.Flush()
-	if *unique {
+	// with -json-array, results become the elements of an array closed at the end
+	writeLine := func(line string) {
+		fmt.Fprintln(w, line)
+	}
+	if *jsonArray {
+		fmt.Fprint(w, "[")
+		separator := "\n"
+		writeLine = func(line string) {
+			fmt.Fprint(w, separator+line)
+			separator = ",\n"
+		}
+		defer fmt.Fprint(w, "\n]\n")
+	}
+	if *sorted {
+		var lines []string
 		for res := range results {
-			if isUnique(res) {
-				fmt.Fprintln(w, res)
+			lines = append(lines, res)
+		}
+		sort.Strings(lines)
+		for i, res := range lines {
+			if i == 0 || res != lines[i-1] {
+				writeLine(res)
 			}
 		}
+		return
 	}
 	for res := range results {
-		fmt.Fprintln(w, res)
+		writeLine(res)
+	}
+
+}
+
+// crawlTarget is a URL to crawl, along with settings overriding the command-line flags for it
+type crawlTarget struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Depth   int               `json:"depth"`
+	// Include limits crawling to URLs matching one of its regexes, Exclude skips the URLs matching one of its regexes
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// readTargets sends the URL given with -u, or else each line of stdin, to the targets chan.
+// Lines of stdin are either plain URLs or JSON objects describing a crawlTarget.
+func readTargets(single string, targets chan crawlTarget) {
+	defer close(targets)
+	if single != "" {
+		targets <- crawlTarget{URL: single}
+		return
+	}
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "{") {
+			targets <- crawlTarget{URL: line}
+			continue
+		}
+		var target crawlTarget
+		if err := json.Unmarshal([]byte(line), &target); err != nil {
+			log.Println("Error parsing target:", err)
+			continue
+		}
+		targets <- target
+	}
+	if err := s.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "reading standard input:", err)
 	}
+}
 
+// compileRegexes compiles a list of regexes, failing on the first invalid one
+func compileRegexes(raw []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, r := range raw {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
 }
 
 // parseHeaders does validation of headers input and saves it to a formatted map.
@@ -232,6 +2007,74 @@ func parseHeaders(rawHeaders string) error {
 	return nil
 }
 
+// loadHostHeaders reads a JSON file mapping host patterns to headers into hostHeaders
+func loadHostHeaders(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, &hostHeaders)
+	if err != nil {
+		return err
+	}
+	for pattern := range hostHeaders {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad host pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// hasCredentials reports whether headers include a Cookie or Authorization header
+func hasCredentials(headers map[string]string) bool {
+	for header, value := range headers {
+		switch http.CanonicalHeaderKey(header) {
+		case "Cookie", "Authorization":
+			if value != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headersForHost merges the headers of every pattern in hostHeaders matching the hostname.
+// Patterns are applied in sorted order, so the result is the same on every request.
+func headersForHost(hostname string) map[string]string {
+	patterns := make([]string, 0, len(hostHeaders))
+	for pattern := range hostHeaders {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	merged := make(map[string]string)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, hostname); ok {
+			for header, value := range hostHeaders[pattern] {
+				merged[header] = value
+			}
+		}
+	}
+	return merged
+}
+
+// parseRawRequest reads a raw HTTP request from a file, as saved by Burp and similar tools
+func parseRawRequest(filename string) (*http.Request, []byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	req, err := http.ReadRequest(bufio.NewReader(f))
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return req, body, nil
+}
+
 // extractHostname() extracts the hostname from a URL and returns it
 func extractHostname(urlString string) (string, error) {
 	u, err := url.Parse(urlString)
@@ -241,27 +2084,302 @@ func extractHostname(urlString string) (string, error) {
 	return u.Hostname(), nil
 }
 
+// urlParams are the names of object and applet params that hold URLs
+var urlParams = map[string]bool{"movie": true, "src": true, "url": true, "href": true, "data": true, "filename": true, "codebase": true, "archive": true}
+
+// looksLikeURL reports whether a param value is an absolute URL or a path
+func looksLikeURL(value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	return strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") || strings.HasPrefix(value, "/")
+}
+
+// appletFiles returns the class file and the comma-separated archives of an applet, relative
+// to its codebase. The class is given as a Java class name, e.g. com.example.Main.
+func appletFiles(code string, archive string) []string {
+	var files []string
+	if code = strings.TrimSpace(code); code != "" {
+		files = append(files, strings.ReplaceAll(strings.TrimSuffix(code, ".class"), ".", "/")+".class")
+	}
+	for _, file := range strings.Split(archive, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// pseudoSchemes are the schemes of links that do not lead to pages, and the source they are output as
+var pseudoSchemes = map[string]string{"javascript": "javascript", "mailto": "email", "tel": "tel", "data": "data"}
+
+// pseudoScheme returns the source of a javascript:, mailto:, tel: or data: link, or ""
+func pseudoScheme(link string) string {
+	i := strings.Index(link, ":")
+	if i < 0 {
+		return ""
+	}
+	return pseudoSchemes[strings.ToLower(strings.TrimSpace(link[:i]))]
+}
+
 // print result constructs output lines and sends them to the results chan
 func printResult(link string, sourceName string, showSource bool, showJson bool, results chan string, e *colly.HTMLElement) {
-	result := e.Request.AbsoluteURL(link)
-	if result != "" {
-		if showJson {
-			bytes, _ := json.Marshal(Result{
-				Source: sourceName,
-				URL:    result,
-			})
-			result = string(bytes)
-		} else if showSource {
-			result = "[" + sourceName + "] " + result
+	// pseudo-URLs are output as they are, under their own source
+	if pseudo := pseudoScheme(link); pseudo != "" {
+		if !skipPseudo || (pseudo == "email" && keepMailto) {
+			sendResult(Result{Source: pseudo, URL: strings.TrimSpace(link)}, showSource, showJson, results)
 		}
-		// If timeout occurs before goroutines are finished, recover from panic that may occur when attempting writing to results to closed results channel
-		defer func() {
-			if err := recover(); err != nil {
-				return
+		return
+	}
+	sendResult(Result{
+		Source: sourceName,
+		URL:    e.Request.AbsoluteURL(link),
+	}, showSource, showJson, results)
+}
+
+// sendResult formats a result and sends it to the results chan
+func sendResult(res Result, showSource bool, showJson bool, results chan string) {
+	if res.URL == "" {
+		return
+	}
+	if excludedURLs[res.URL] {
+		return
+	}
+	if outputSources != nil && !outputSources[res.Source] {
+		return
+	}
+	if res.Match == "" {
+		res.RedirectParams = redirectParams(res.URL)
+	}
+	categorize(&res)
+	if minSeverity >= 0 && (res.Category == "" || severityLevel(res.Severity) < minSeverity) {
+		return
+	}
+	for _, p := range activeProcessors {
+		if !p.OnResult(&res) {
+			return
+		}
+	}
+	if redirectsOnly && res.RedirectParams == nil {
+		return
+	}
+	if uniqueHosts {
+		hostname, err := extractHostname(res.URL)
+		if err != nil {
+			return
+		}
+		if _, seen := hostsSeen.LoadOrStore(hostname, true); seen {
+			return
+		}
+	}
+	if resultIPs != nil {
+		if hostname, err := extractHostname(res.URL); err == nil && hostname != "" {
+			res.IP = resultIPs.lookup(hostname)
+		}
+	}
+	result := res.URL
+	if res.Match != "" {
+		result = res.Match
+	}
+	if outputTemplate != nil {
+		var line strings.Builder
+		if err := outputTemplate.Execute(&line, res); err != nil {
+			log.Println("Error executing output template:", err)
+			return
+		}
+		result = line.String()
+	} else if showJson && outputFields != nil {
+		result = selectFields(res, outputFields)
+	} else if showJson {
+		bytes, _ := json.Marshal(res)
+		result = string(bytes)
+	} else {
+		if res.IP != "" {
+			result += " " + res.IP
+		}
+		if showSource {
+			result = "[" + res.Source + "] " + result
+		}
+	}
+	// repeated lines are dropped before they count towards -max-results
+	if uniqueLines && !isUnique(result) {
+		return
+	}
+	sent := atomic.AddInt64(&resultsSent, 1)
+	if limit := atomic.LoadInt64(&resultLimit); limit > 0 && sent > limit {
+		return
+	}
+	if report != nil {
+		report.add(res)
+	}
+	if stats != nil {
+		stats.result(res)
+	}
+	if hostOutput != nil {
+		hostname, _ := extractHostname(res.URL)
+		if err := hostOutput.write(hostname, result); err != nil {
+			log.Println("Error writing output file:", err)
+		}
+	}
+	// If timeout occurs before goroutines are finished, recover from panic that may occur when attempting writing to results to closed results channel
+	defer func() {
+		if err := recover(); err != nil {
+			return
+		}
+	}()
+	results <- result
+}
+
+// parseFields turns the field names given to -fields into Result field names. Names are
+// matched ignoring case and dashes, so content-type selects ContentType.
+func parseFields(list string) ([]string, error) {
+	known := make(map[string]string)
+	t := reflect.TypeOf(Result{})
+	for i := 0; i < t.NumField(); i++ {
+		known[strings.ToLower(t.Field(i).Name)] = t.Field(i).Name
+	}
+	var selected []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.NewReplacer("-", "", "_", "", " ", "").Replace(name))
+		if name == "" {
+			continue
+		}
+		field, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		selected = append(selected, field)
+	}
+	return selected, nil
+}
+
+// selectFields formats a result as a JSON object of the given fields, in order. Empty fields
+// are left out, as in full JSON output.
+func selectFields(res Result, fields []string) string {
+	v := reflect.ValueOf(res)
+	var b strings.Builder
+	b.WriteString("{")
+	for _, field := range fields {
+		value := v.FieldByName(field)
+		if value.IsZero() {
+			continue
+		}
+		if b.Len() > 1 {
+			b.WriteString(",")
+		}
+		key, _ := json.Marshal(field)
+		data, _ := json.Marshal(value.Interface())
+		b.Write(key)
+		b.WriteString(":")
+		b.Write(data)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// flushPending prints a link held back by -show-final using the URL the request finally landed on
+func flushPending(r *colly.Response, showSource bool, showJson bool, results chan string) {
+	original, ok := origins.Load(r.Request.ID)
+	if !ok {
+		return
+	}
+	source, ok := pending.LoadAndDelete(original)
+	if !ok {
+		return
+	}
+	res := Result{Source: source.(string), URL: r.Request.URL.String(), Status: r.StatusCode}
+	if r.Headers != nil {
+		res.ContentType = r.Headers.Get("Content-Type")
+		res.Headers = selectHeaders(r.Headers)
+	}
+	setTiming(&res, r)
+	if res.URL != original.(string) {
+		res.OriginalURL = original.(string)
+	}
+	sendResult(res, showSource, showJson, results)
+}
+
+// selectHeaders returns the values of the -include-headers headers sent in a response, or nil
+func selectHeaders(h *http.Header) map[string]string {
+	var selected map[string]string
+	for _, name := range includedHeaders {
+		if values := h.Values(name); values != nil {
+			if selected == nil {
+				selected = make(map[string]string)
 			}
-		}()
-		results <- result
+			selected[name] = strings.Join(values, ", ")
+		}
+	}
+	return selected
+}
+
+// setTiming sets the -timing timings of the request of a response on a result
+func setTiming(res *Result, r *colly.Response) {
+	if requestTimings == nil {
+		return
+	}
+	if timing, ok := requestTimings.get(r.Request.ID); ok {
+		res.TTFB, res.Duration = timing.ttfb, timing.duration
+	}
+}
+
+// extractMatches returns a result for every match of re in body, with its named groups
+func extractMatches(re *regexp.Regexp, body []byte) []Result {
+	var found []Result
+	names := re.SubexpNames()
+	for _, m := range re.FindAllSubmatch(body, -1) {
+		res := Result{Source: "regex", Match: string(m[0])}
+		for i, name := range names {
+			if name != "" && m[i] != nil {
+				if res.Groups == nil {
+					res.Groups = make(map[string]string)
+				}
+				res.Groups[name] = string(m[i])
+			}
+		}
+		found = append(found, res)
+	}
+	return found
+}
+
+// mimeAllowed reports whether a Content-Type header matches one of the allowed media types.
+// A missing Content-Type is allowed, as the body may still turn out to be HTML.
+func mimeAllowed(contentType string, allowed []string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(strings.TrimSpace(a), mediaType) {
+			return true
+		}
 	}
+	return false
+}
+
+// pageExtensions are file extensions that are expected to serve crawlable pages
+var pageExtensions = map[string]bool{
+	"":        true,
+	".html":   true,
+	".htm":    true,
+	".xhtml":  true,
+	".shtml":  true,
+	".php":    true,
+	".asp":    true,
+	".aspx":   true,
+	".jsp":    true,
+	".jspx":   true,
+	".do":     true,
+	".action": true,
+	".cfm":    true,
+	".cgi":    true,
+	".pl":     true,
+}
+
+// isPageExtension reports whether a URL path ends in an extension known to serve pages
+func isPageExtension(urlPath string) bool {
+	return pageExtensions[strings.ToLower(path.Ext(urlPath))]
 }
 
 // returns whether the supplied url is unique or not
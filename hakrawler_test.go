@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	fields, err := parseFields("url, content-type,STATUS,,redirect_params")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"URL", "ContentType", "Status", "RedirectParams"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("parseFields = %q, want %q", fields, want)
+	}
+	if _, err := parseFields("url,bogus"); err == nil {
+		t.Error("parseFields accepted an unknown field")
+	}
+}
+
+func TestSelectFields(t *testing.T) {
+	res := Result{Source: "href", URL: "https://example.com/?next=https://evil.com", Status: 200, RedirectParams: []string{"next"}}
+	tests := []struct {
+		fields []string
+		want   string
+	}{
+		{[]string{"URL", "Status"}, `{"URL":"https://example.com/?next=https://evil.com","Status":200}`},
+		{[]string{"Status", "Source"}, `{"Status":200,"Source":"href"}`},
+		{[]string{"RedirectParams"}, `{"RedirectParams":["next"]}`},
+		// empty fields are left out
+		{[]string{"ContentType", "Source"}, `{"Source":"href"}`},
+		{[]string{"Match"}, `{}`},
+	}
+	for _, test := range tests {
+		if got := selectFields(res, test.fields); got != test.want {
+			t.Errorf("selectFields(%q) = %s, want %s", test.fields, got, test.want)
+		}
+	}
+}
+
+func TestSendResultUniqueMaxResults(t *testing.T) {
+	defer func() {
+		uniqueLines = false
+		resultsSent, resultLimit = 0, 0
+	}()
+	uniqueLines = true
+	resultsSent, resultLimit = 0, 2
+
+	results := make(chan string, 10)
+	for _, u := range []string{"https://example.com/a", "https://example.com/a", "https://example.com/a", "https://example.com/b", "https://example.com/c"} {
+		sendResult(Result{Source: "href", URL: u}, false, false, results)
+	}
+	close(results)
+	var lines []string
+	for line := range results {
+		lines = append(lines, line)
+	}
+	// duplicates neither show nor count towards the limit
+	if want := []string{"https://example.com/a", "https://example.com/b"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("sent %q, want %q", lines, want)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// hstsMinAge is the shortest HSTS max-age not reported as weak, one year as HSTS preload lists require
+const hstsMinAge = 31536000
+
+// headerStats counts the pages of a host missing or sending weak security headers
+type headerStats struct {
+	Host  string
+	Pages int
+	// HTTPS counts the pages served over HTTPS, the only ones HSTS applies to
+	HTTPS                int
+	NoHSTS               int
+	WeakHSTS             int
+	NoFrameOptions       int
+	NoCSP                int
+	NoContentTypeOptions int
+}
+
+// headerAudit collects the security headers of the HTML pages crawled, for -header-audit
+type headerAudit struct {
+	mu    sync.Mutex
+	hosts map[string]*headerStats
+}
+
+func newHeaderAudit() *headerAudit {
+	return &headerAudit{hosts: make(map[string]*headerStats)}
+}
+
+// check records the security headers of a response, if it is an HTML page
+func (a *headerAudit) check(r *colly.Response) {
+	if r.Headers == nil || mediaType(r) != "text/html" {
+		return
+	}
+	csp := strings.ToLower(strings.Join(r.Headers.Values("Content-Security-Policy"), ";"))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats, ok := a.hosts[r.Request.URL.Host]
+	if !ok {
+		stats = &headerStats{Host: r.Request.URL.Host}
+		a.hosts[r.Request.URL.Host] = stats
+	}
+	stats.Pages++
+	if r.Request.URL.Scheme == "https" {
+		stats.HTTPS++
+		switch age := hstsMaxAge(r.Headers.Get("Strict-Transport-Security")); {
+		case age < 0:
+			stats.NoHSTS++
+		case age < hstsMinAge:
+			stats.WeakHSTS++
+		}
+	}
+	// frame-ancestors supersedes X-Frame-Options, whose ALLOW-FROM browsers ignore
+	frameOptions := strings.ToUpper(strings.TrimSpace(r.Headers.Get("X-Frame-Options")))
+	if frameOptions != "DENY" && frameOptions != "SAMEORIGIN" && !strings.Contains(csp, "frame-ancestors") {
+		stats.NoFrameOptions++
+	}
+	if csp == "" {
+		stats.NoCSP++
+	}
+	if !strings.EqualFold(strings.TrimSpace(r.Headers.Get("X-Content-Type-Options")), "nosniff") {
+		stats.NoContentTypeOptions++
+	}
+}
+
+// hstsMaxAge returns the max-age of a Strict-Transport-Security header, or -1 if it has none
+func hstsMaxAge(header string) int {
+	for _, directive := range strings.Split(header, ";") {
+		parts := strings.SplitN(strings.TrimSpace(directive), "=", 2)
+		if len(parts) == 2 && strings.EqualFold(parts[0], "max-age") {
+			if age, err := strconv.Atoi(strings.Trim(parts[1], `"`)); err == nil {
+				return age
+			}
+		}
+	}
+	return -1
+}
+
+// writeMarkdown writes a table of how many pages of every host miss each header, or send a weak one, to filename
+func (a *headerAudit) writeMarkdown(filename string) error {
+	a.mu.Lock()
+	var hosts []*headerStats
+	for _, stats := range a.hosts {
+		hosts = append(hosts, stats)
+	}
+	a.mu.Unlock()
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Security headers\n\nHTML pages missing each header, or sending a weak one, out of the pages crawled. HSTS is only checked over HTTPS, and counted as weak under a max-age of %d.\n\n", hstsMinAge)
+	b.WriteString("| Host | Pages | No HSTS | Weak HSTS | No X-Frame-Options or frame-ancestors | No CSP | No X-Content-Type-Options |\n| --- | --- | --- | --- | --- | --- | --- |\n")
+	for _, s := range hosts {
+		hsts, weak := "-", "-"
+		if s.HTTPS > 0 {
+			hsts, weak = fmt.Sprintf("%d/%d", s.NoHSTS, s.HTTPS), fmt.Sprintf("%d/%d", s.WeakHSTS, s.HTTPS)
+		}
+		fmt.Fprintf(&b, "| %s | %d | %s | %s | %d/%d | %d/%d | %d/%d |\n", markdownEscape(s.Host), s.Pages, hsts, weak,
+			s.NoFrameOptions, s.Pages, s.NoCSP, s.Pages, s.NoContentTypeOptions, s.Pages)
+	}
+	return os.WriteFile(filename, []byte(b.String()), 0644)
+}
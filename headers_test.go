@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestHSTSMaxAge(t *testing.T) {
+	tests := []struct {
+		header string
+		age    int
+	}{
+		{"", -1},
+		{"max-age=31536000", 31536000},
+		{"max-age=63072000; includeSubDomains; preload", 63072000},
+		{"includeSubDomains; Max-Age=300", 300},
+		{`max-age="86400"`, 86400},
+		{"max-age=0", 0},
+		{"max-age=forever", -1},
+		{"includeSubDomains", -1},
+	}
+	for _, test := range tests {
+		if age := hstsMaxAge(test.header); age != test.age {
+			t.Errorf("hstsMaxAge(%q) = %d, want %d", test.header, age, test.age)
+		}
+	}
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chromeBinaries are the names headless Chrome is looked up by when -chrome is not given
+var chromeBinaries = []string{
+	"chromium",
+	"chromium-browser",
+	"google-chrome",
+	"google-chrome-stable",
+	"chrome",
+	"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+}
+
+// unsafeFilename matches the characters not kept when turning a URL into a file name
+var unsafeFilename = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// chromeRenderer renders pages with headless Chrome, one process per page, driven over the
+// DevTools protocol. Chrome is given the crawl's headers and cookies, so pages are rendered as
+// the crawler sees them.
+type chromeRenderer struct {
+	binary  string
+	args    []string
+	timeout time.Duration
+	// the captures run on every page: the directories screenshots and DOM snapshots are saved
+	// to, if any, and whether the requests pages make are recorded
+	screenshotDir string
+	domDir        string
+	xhr           bool
+	// scrolls is how many times at most pages are scrolled down by a viewport, and idle how
+	// long scripts are given to load content once the page has loaded and after every scroll
+	scrolls int
+	idle    time.Duration
+	// clicks and clickTime are the budget of -click per page, none without it, and clickSkip
+	// matches the labels of the elements not to click
+	clicks    int
+	clickTime time.Duration
+	clickSkip *regexp.Regexp
+	// slots limits how many Chrome processes run at once
+	slots chan struct{}
+	mu    sync.Mutex
+	index map[string]*json.Encoder
+}
+
+// newChromeRenderer finds the Chrome binary to use, and passes on the proxy and TLS settings.
+// Chrome keeps its sandbox unless noSandbox is set, or we run as root, where it refuses to
+// start sandboxed.
+func newChromeRenderer(binary string, proxy string, insecure bool, noSandbox bool, parallel int) (*chromeRenderer, error) {
+	if binary == "" {
+		for _, name := range chromeBinaries {
+			if path, err := exec.LookPath(name); err == nil {
+				binary = path
+				break
+			}
+		}
+		if binary == "" {
+			return nil, errors.New("no Chrome or Chromium binary found, use -chrome to point to one")
+		}
+	}
+	args := []string{"--headless", "--disable-gpu", "--hide-scrollbars"}
+	if noSandbox || os.Geteuid() == 0 {
+		args = append(args, "--no-sandbox")
+	}
+	if proxy != "" {
+		args = append(args, "--proxy-server="+proxy)
+	}
+	if insecure {
+		args = append(args, "--ignore-certificate-errors")
+	}
+	return &chromeRenderer{
+		binary:  binary,
+		args:    args,
+		timeout: 30 * time.Second,
+		idle:    5 * time.Second,
+		slots:   make(chan struct{}, parallel),
+		index:   make(map[string]*json.Encoder),
+	}, nil
+}
+
+// renderedPage is what capturing a rendered page turned up
+type renderedPage struct {
+	// requests are the XHR and fetch requests the page made on its own
+	requests []netRequest
+	// navigations are the URLs clicking through the page navigated to, and clickRequests the
+	// XHR and fetch requests the clicks made
+	navigations   []string
+	clickRequests []netRequest
+}
+
+// capture renders a page once, with the given headers and cookies, and runs the enabled
+// captures on it, in order: the screenshot, the DOM snapshot, the requests the page made, and
+// clicking through it. What was captured before an error is returned with it.
+func (r *chromeRenderer) capture(pageURL string, headers http.Header, cookies []*http.Cookie) (*renderedPage, error) {
+	rendered := &renderedPage{}
+	err := r.render(pageURL, headers, cookies, func(page *chromePage) error {
+		if r.screenshotDir != "" {
+			if err := r.screenshot(page, pageURL); err != nil {
+				return fmt.Errorf("screenshot: %v", err)
+			}
+		}
+		if r.domDir != "" {
+			if err := r.snapshotDOM(page, pageURL); err != nil {
+				return fmt.Errorf("DOM snapshot: %v", err)
+			}
+		}
+		if r.xhr {
+			rendered.requests = page.xhrs()
+		}
+		if r.clicks > 0 {
+			return r.explore(page, pageURL, rendered)
+		}
+		return nil
+	})
+	return rendered, err
+}
+
+// maxScreenshotHeight is the height screenshots of long pages are cut at, in CSS pixels
+const maxScreenshotHeight = 16384
+
+// screenshot saves a PNG of the page in the screenshot directory and records it in its index.jsonl
+func (r *chromeRenderer) screenshot(page *chromePage, url string) error {
+	var metrics struct {
+		ContentSize struct {
+			Width  float64 `json:"width"`
+			Height float64 `json:"height"`
+		} `json:"cssContentSize"`
+	}
+	if err := page.call("Page.getLayoutMetrics", nil, &metrics); err != nil {
+		return err
+	}
+	width, height := metrics.ContentSize.Width, metrics.ContentSize.Height
+	if width == 0 || height == 0 {
+		width, height = 1280, 800
+	}
+	if height > maxScreenshotHeight {
+		height = maxScreenshotHeight
+	}
+	var shot struct {
+		Data []byte `json:"data"`
+	}
+	err := page.call("Page.captureScreenshot", map[string]interface{}{
+		"format":                "png",
+		"captureBeyondViewport": true,
+		"clip":                  map[string]float64{"x": 0, "y": 0, "width": width, "height": height, "scale": 1},
+	}, &shot)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(r.screenshotDir, pageFilename(url)+".png")
+	if err := os.WriteFile(file, shot.Data, 0644); err != nil {
+		return err
+	}
+	return r.record(r.screenshotDir, url, file)
+}
+
+// snapshotDOM saves the page's DOM, as serialized once its scripts have run, in the DOM
+// snapshot directory and records it in its index.jsonl
+func (r *chromeRenderer) snapshotDOM(page *chromePage, url string) error {
+	var dom string
+	if err := page.evaluate("document.documentElement.outerHTML", &dom); err != nil {
+		return err
+	}
+	file := filepath.Join(r.domDir, pageFilename(url)+".html")
+	if err := os.WriteFile(file, []byte(dom), 0644); err != nil {
+		return err
+	}
+	return r.record(r.domDir, url, file)
+}
+
+// record adds a page and the file saved for it to the index of a directory
+func (r *chromeRenderer) record(dir string, url string, file string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	index, ok := r.index[dir]
+	if !ok {
+		f, err := os.OpenFile(filepath.Join(dir, "index.jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		index = json.NewEncoder(f)
+		r.index[dir] = index
+	}
+	return index.Encode(struct {
+		URL  string
+		File string
+	}{url, filepath.Base(file)})
+}
+
+// pageFilename turns a URL into a readable file name, made unique by a hash of the URL
+func pageFilename(url string) string {
+	sum := sha1.Sum([]byte(url))
+	name := strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://")
+	name = strings.Trim(unsafeFilename.ReplaceAllString(name, "_"), "_")
+	if len(name) > 80 {
+		name = name[:80]
+	}
+	return name + "_" + hex.EncodeToString(sum[:4])
+}
+
+// netRequest is an XHR or fetch request made by a page while it was rendered
+type netRequest struct {
+	Method string
+	URL    string
+	// ContentType and Params are the content type of the request body, and the parameter or
+	// key names it holds
+	ContentType string
+	Params      []string
+}
+
+// chromePage is a page rendered in a Chrome process of its own, and the requests it made
+type chromePage struct {
+	browser *devtools
+	session string
+	ctx     context.Context
+	// frame is the ID of the page's main frame
+	frame    string
+	mu       sync.Mutex
+	requests []netRequest
+	// loaded is signalled when the page fires its load event
+	loaded chan struct{}
+	// while clicking, navigated is set when the main frame starts loading another document,
+	// and navigations are the URLs the page navigated to
+	clicking    bool
+	navigated   bool
+	navigations []string
+}
+
+// render opens a page in a new Chrome process, sending the headers with all of its requests
+// and setting the cookies for its URL. It waits until the page has loaded and its scripts have
+// been given the idle time, scrolls it down, and passes it to use.
+func (r *chromeRenderer) render(pageURL string, headers http.Header, cookies []*http.Cookie, use func(*chromePage) error) error {
+	r.slots <- struct{}{}
+	defer func() { <-r.slots }()
+	// the time given to scripts comes on top of the time given to Chrome
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout+r.idle*time.Duration(r.scrolls+1)+r.clickTime)
+	defer cancel()
+
+	page := &chromePage{ctx: ctx, loaded: make(chan struct{}, 1)}
+	browser, err := startDevtools(ctx, r.binary, append(append([]string{}, r.args...), "--window-size=1280,800"), page.event)
+	if err != nil {
+		return err
+	}
+	defer browser.close()
+	page.browser = browser
+
+	var target struct {
+		TargetID string `json:"targetId"`
+	}
+	if err := browser.call("", "Target.createTarget", map[string]string{"url": "about:blank"}, &target); err != nil {
+		return err
+	}
+	var session struct {
+		SessionID string `json:"sessionId"`
+	}
+	if err := browser.call("", "Target.attachToTarget", map[string]interface{}{"targetId": target.TargetID, "flatten": true}, &session); err != nil {
+		return err
+	}
+	page.session = session.SessionID
+	for _, method := range []string{"Page.enable", "Network.enable"} {
+		if err := page.call(method, nil, nil); err != nil {
+			return err
+		}
+	}
+	if len(headers) > 0 {
+		extra := make(map[string]string)
+		for header := range headers {
+			// Chrome sets the Host header from the URL
+			if header != "Host" {
+				extra[header] = headers.Get(header)
+			}
+		}
+		if err := page.call("Network.setExtraHTTPHeaders", map[string]interface{}{"headers": extra}, nil); err != nil {
+			return err
+		}
+	}
+	if len(cookies) > 0 {
+		// the cookie jar does not tell the domain and path cookies were set for, so they are
+		// set for the page's host and all of its paths
+		var params []map[string]interface{}
+		for _, cookie := range cookies {
+			params = append(params, map[string]interface{}{"name": cookie.Name, "value": cookie.Value, "url": pageURL, "path": "/"})
+		}
+		if err := page.call("Network.setCookies", map[string]interface{}{"cookies": params}, nil); err != nil {
+			return err
+		}
+	}
+	if err := page.navigate(pageURL); err != nil {
+		return err
+	}
+	if err := idle(ctx, r.idle); err != nil {
+		return err
+	}
+	if err := page.scroll(ctx, r.scrolls, r.idle); err != nil {
+		return err
+	}
+	return use(page)
+}
+
+// idle waits for scripts to load content for a while, or until ctx is done
+func idle(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// scroll scrolls the page down a viewport at a time, giving scripts the idle time after every
+// step to load lazy content or the next batch of an infinite scroll. It stops once the bottom
+// of the page is reached and it did not grow, or after the given number of steps.
+func (p *chromePage) scroll(ctx context.Context, steps int, wait time.Duration) error {
+	for i := 0; i < steps; i++ {
+		var before float64
+		if err := p.evaluate("window.scrollBy(0, window.innerHeight); document.documentElement.scrollHeight", &before); err != nil {
+			return err
+		}
+		if err := idle(ctx, wait); err != nil {
+			return err
+		}
+		var position struct {
+			Bottom float64 `json:"bottom"`
+			Height float64 `json:"height"`
+		}
+		if err := p.evaluate("({bottom: window.scrollY + window.innerHeight, height: document.documentElement.scrollHeight})", &position); err != nil {
+			return err
+		}
+		if position.Bottom >= position.Height && position.Height <= before {
+			break
+		}
+	}
+	return nil
+}
+
+// evaluate runs a JavaScript expression in the page and decodes its value into result
+func (p *chromePage) evaluate(expression string, result interface{}) error {
+	var evaluation struct {
+		Result struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Text string `json:"text"`
+		} `json:"exceptionDetails"`
+	}
+	if err := p.call("Runtime.evaluate", map[string]interface{}{"expression": expression, "returnByValue": true}, &evaluation); err != nil {
+		return err
+	}
+	if evaluation.ExceptionDetails != nil {
+		return errors.New(evaluation.ExceptionDetails.Text)
+	}
+	if len(evaluation.Result.Value) == 0 {
+		return nil
+	}
+	return json.Unmarshal(evaluation.Result.Value, result)
+}
+
+// call sends a command to the page
+func (p *chromePage) call(method string, params interface{}, result interface{}) error {
+	return p.browser.call(p.session, method, params, result)
+}
+
+// navigate loads a URL in the page and waits for its load event
+func (p *chromePage) navigate(pageURL string) error {
+	// a load event left from before is not this one's
+	select {
+	case <-p.loaded:
+	default:
+	}
+	var navigation struct {
+		FrameID   string `json:"frameId"`
+		ErrorText string `json:"errorText"`
+	}
+	if err := p.call("Page.navigate", map[string]string{"url": pageURL}, &navigation); err != nil {
+		return err
+	}
+	if navigation.ErrorText != "" {
+		return errors.New(navigation.ErrorText)
+	}
+	p.mu.Lock()
+	p.frame = navigation.FrameID
+	p.mu.Unlock()
+	select {
+	case <-p.loaded:
+		return nil
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+}
+
+// event records the XHR and fetch requests of the page, and when it has loaded
+func (p *chromePage) event(message devtoolsMessage) {
+	// the browser's own events are not about the page
+	if message.SessionID == "" {
+		return
+	}
+	switch message.Method {
+	case "Page.loadEventFired":
+		select {
+		case p.loaded <- struct{}{}:
+		default:
+		}
+	case "Page.javascriptDialogOpening":
+		// dialogs block the page until closed, and confirmations are better declined. The
+		// reply to the command comes through this goroutine, so it is not waited for here.
+		go p.call("Page.handleJavaScriptDialog", map[string]bool{"accept": false}, nil)
+	case "Page.frameStartedLoading", "Page.frameNavigated", "Page.navigatedWithinDocument":
+		var params struct {
+			FrameID string `json:"frameId"`
+			URL     string `json:"url"`
+			Frame   struct {
+				ID  string `json:"id"`
+				URL string `json:"url"`
+			} `json:"frame"`
+		}
+		if json.Unmarshal(message.Params, &params) != nil {
+			return
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if !p.clicking {
+			return
+		}
+		switch {
+		case message.Method == "Page.frameStartedLoading" && params.FrameID == p.frame:
+			p.navigated = true
+		case message.Method == "Page.frameNavigated" && params.Frame.ID == p.frame:
+			p.navigations = append(p.navigations, params.Frame.URL)
+		case message.Method == "Page.navigatedWithinDocument" && params.FrameID == p.frame:
+			// routes of single page apps, changed with the History API or the fragment
+			p.navigations = append(p.navigations, params.URL)
+		}
+	case "Network.requestWillBeSent":
+		var params struct {
+			Type    string `json:"type"`
+			Request struct {
+				URL      string            `json:"url"`
+				Method   string            `json:"method"`
+				Headers  map[string]string `json:"headers"`
+				PostData string            `json:"postData"`
+			} `json:"request"`
+		}
+		if json.Unmarshal(message.Params, &params) != nil || (params.Type != "XHR" && params.Type != "Fetch") {
+			return
+		}
+		req := netRequest{Method: params.Request.Method, URL: params.Request.URL}
+		for name, value := range params.Request.Headers {
+			if strings.EqualFold(name, "Content-Type") {
+				req.ContentType = value
+			}
+		}
+		req.Params = bodyParams(req.ContentType, params.Request.PostData)
+		p.mu.Lock()
+		p.requests = append(p.requests, req)
+		p.mu.Unlock()
+	}
+}
+
+// xhrs returns the XHR and fetch requests the page made so far, each method and URL once
+func (p *chromePage) xhrs() []netRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var requests []netRequest
+	seen := make(map[string]bool)
+	for _, req := range p.requests {
+		if key := req.Method + " " + req.URL; !seen[key] {
+			seen[key] = true
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
+
+// clickSettle is how long the page is given to react to a click
+const clickSettle = time.Second
+
+// clickTargets lists the elements of the page a click may do something on, and returns their
+// labels: buttons, elements with a click handler property or a button role, and links to
+// scripts or to the page itself. Hidden and disabled elements, and the buttons submitting
+// forms, are left out. The elements are kept for clickTarget.
+const clickTargets = `(() => {
+	const selector = 'button, input[type=button], [role=button], [onclick], a[href^="javascript:"], a[href="#"]';
+	const elements = Array.from(document.querySelectorAll('*'))
+		.filter(el => el.matches(selector) || typeof el.onclick === 'function')
+		.filter(el => !el.disabled && el.getClientRects().length > 0 && !(el.form && el.type === 'submit'));
+	window.__hakrawlerClickTargets = elements;
+	return elements.map(el => (el.innerText || el.value || el.getAttribute('aria-label') || el.title || el.id || '').trim().slice(0, 200));
+})()`
+
+// clickTarget clicks the element of clickTargets at an index, if it is still in the page
+const clickTarget = `(() => {
+	const el = (window.__hakrawlerClickTargets || [])[%d];
+	if (el && el.isConnected) {
+		el.click();
+	}
+})()`
+
+// explore clicks the buttons and the elements with click handlers of a rendered page one at a
+// time, until the click or time budget runs out. It records the URLs the clicks navigated to,
+// and the XHR and fetch requests they made. The page is loaded again after navigating away.
+func (r *chromeRenderer) explore(page *chromePage, pageURL string, rendered *renderedPage) error {
+	before := make(map[string]bool)
+	for _, req := range page.xhrs() {
+		before[req.Method+" "+req.URL] = true
+	}
+	var labels []string
+	if err := page.evaluate(clickTargets, &labels); err != nil {
+		return err
+	}
+
+	page.mu.Lock()
+	page.clicking = true
+	page.mu.Unlock()
+	start := time.Now()
+	clicks := 0
+	for i := 0; i < len(labels) && clicks < r.clicks && time.Since(start) < r.clickTime; i++ {
+		// the skip regex is written for URLs, whose words are not separated by spaces
+		if r.clickSkip != nil && r.clickSkip.MatchString(strings.Join(strings.Fields(labels[i]), "-")) {
+			continue
+		}
+		page.mu.Lock()
+		page.navigated = false
+		page.mu.Unlock()
+		// the element may have gone, or taken the page with it
+		page.evaluate(fmt.Sprintf(clickTarget, i), nil)
+		clicks++
+		if err := idle(page.ctx, clickSettle); err != nil {
+			return err
+		}
+
+		page.mu.Lock()
+		navigated := page.navigated
+		page.mu.Unlock()
+		if !navigated {
+			continue
+		}
+		if err := page.navigate(pageURL); err != nil {
+			return err
+		}
+		if err := idle(page.ctx, clickSettle); err != nil {
+			return err
+		}
+		labels = nil
+		if err := page.evaluate(clickTargets, &labels); err != nil {
+			return err
+		}
+	}
+
+	page.mu.Lock()
+	seen := map[string]bool{pageURL: true}
+	for _, u := range page.navigations {
+		if !seen[u] && (strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://")) {
+			seen[u] = true
+			rendered.navigations = append(rendered.navigations, u)
+		}
+	}
+	page.mu.Unlock()
+	for _, req := range page.xhrs() {
+		if !before[req.Method+" "+req.URL] {
+			rendered.clickRequests = append(rendered.clickRequests, req)
+		}
+	}
+	return nil
+}
+
+// bodyParams returns the parameter or key names of a request body: the names of form fields,
+// or the keys of JSON objects, nested ones joined to theirs by dots
+func bodyParams(contentType string, body string) []string {
+	if body == "" {
+		return nil
+	}
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	var names []string
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		values, _ := url.ParseQuery(body)
+		for name := range values {
+			names = append(names, name)
+		}
+	case "multipart/form-data":
+		reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			names = append(names, part.FormName())
+		}
+	default:
+		// fetch sends JSON as text/plain unless told otherwise
+		var value interface{}
+		if json.Unmarshal([]byte(body), &value) == nil {
+			names = jsonKeys("", value, names)
+		}
+	}
+
+	sort.Strings(names)
+	var unique []string
+	for i, name := range names {
+		if name != "" && (i == 0 || name != names[i-1]) {
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
+
+// jsonKeys appends the keys of the objects in a JSON value to names, after prefix
+func jsonKeys(prefix string, value interface{}, names []string) []string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			names = append(names, prefix+key)
+			names = jsonKeys(prefix+key+".", child, names)
+		}
+	case []interface{}:
+		for _, child := range v {
+			names = jsonKeys(prefix, child, names)
+		}
+	}
+	return names
+}
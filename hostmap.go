@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// hostMap pins hostnames to IP addresses, for -host-map, like /etc/hosts entries would
+type hostMap map[string]string
+
+// add parses a "hostname=IP" mapping
+func (m hostMap) add(mapping string) error {
+	parts := strings.SplitN(mapping, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected hostname=IP, got %q", mapping)
+	}
+	host, ip := strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+	if host == "" || net.ParseIP(ip) == nil {
+		return fmt.Errorf("expected hostname=IP, got %q", mapping)
+	}
+	m[host] = ip
+	return nil
+}
+
+// load reads mappings from a file, one per line, either as hostname=IP or in the /etc/hosts
+// format of an IP followed by hostnames
+func (m hostMap) load(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if strings.Contains(text, "=") {
+			if err := m.add(text); err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+			return fmt.Errorf("line %d: expected hostname=IP or an IP followed by hostnames", line)
+		}
+		for _, host := range fields[1:] {
+			m[strings.ToLower(host)] = fields[0]
+		}
+	}
+	return scanner.Err()
+}
+
+// dialContext returns a dial function connecting to the pinned IP of mapped hostnames, and
+// leaving the others to dial, or to defaultDialer if nil
+func (m hostMap) dialContext(dial func(ctx context.Context, network string, address string) (net.Conn, error)) func(ctx context.Context, network string, address string) (net.Conn, error) {
+	if dial == nil {
+		dial = defaultDialer.DialContext
+	}
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err == nil {
+			if ip, ok := m[strings.ToLower(strings.TrimSuffix(host, "."))]; ok {
+				address = net.JoinHostPort(ip, port)
+			}
+		}
+		return dial(ctx, network, address)
+	}
+}
+
+// resolverRules returns the mappings as Chrome's --host-resolver-rules
+func (m hostMap) resolverRules() string {
+	var rules []string
+	for host, ip := range m {
+		if strings.Contains(ip, ":") {
+			ip = "[" + ip + "]"
+		}
+		rules = append(rules, "MAP "+host+" "+ip)
+	}
+	sort.Strings(rules)
+	return strings.Join(rules, ", ")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ipResolver finds the IP addresses hostnames resolve to, like the crawl resolves them, with
+// -host-map and -doh. It resolves the hosts of results for -show-ip, and those -scope-cidr checks.
+type ipResolver struct {
+	hosts hostMap
+	doh   *dohResolver
+	ips   sync.Map
+}
+
+// IP addresses of result hosts, set with -show-ip, or nil
+var resultIPs *ipResolver
+
+// resolve returns the IP addresses of a hostname, or none if it does not resolve
+func (r *ipResolver) resolve(host string) []net.IP {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+	if ip, ok := r.hosts[host]; ok {
+		return []net.IP{net.ParseIP(ip)}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var ips []net.IP
+	if r.doh != nil {
+		ips, _ = r.doh.lookup(ctx, host)
+	} else if addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host); err == nil {
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	return ips
+}
+
+// lookup returns the IP address of a hostname, the first IPv4 one if there is any, or "" if
+// it does not resolve. Each hostname is resolved only once.
+func (r *ipResolver) lookup(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip, ok := r.ips.Load(host); ok {
+		return ip.(string)
+	}
+	ip := ""
+	for _, candidate := range r.resolve(host) {
+		if ip == "" || (candidate.To4() != nil && net.ParseIP(ip).To4() == nil) {
+			ip = candidate.String()
+		}
+	}
+	r.ips.Store(host, ip)
+	return ip
+}
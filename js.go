@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Base URLs set by <base href> tags, keyed by the URL of their page, and by the URL of the
+// scripts found on it. The URLs a script requests are relative to the base URL of the page
+// running it, not to the script itself.
+var pageBases, scriptBases sync.Map
+
+// loadBase returns the base URL stored under a page or script URL, or ""
+func loadBase(bases *sync.Map, u string) string {
+	if base, ok := bases.Load(u); ok {
+		return base.(string)
+	}
+	return ""
+}
+
+// resolveAgainst resolves link against base, or against the request's own URL if base is ""
+func resolveAgainst(r *colly.Request, base string, link string) string {
+	if base == "" {
+		return r.AbsoluteURL(link)
+	}
+	b, err := r.URL.Parse(base)
+	if err != nil {
+		return r.AbsoluteURL(link)
+	}
+	u, err := b.Parse(link)
+	if err != nil {
+		return ""
+	}
+	u.Fragment = ""
+	return u.String()
+}
+
+// jsContentTypes are the Content-Types JavaScript files are served with
+var jsContentTypes = []string{"application/javascript", "text/javascript", "application/x-javascript", "application/ecmascript"}
+
+var (
+	// routePattern matches route definitions of Angular, React Router and Vue Router, both in
+	// source and in compiled bundles, e.g. {path: "users/:id", component: ...}
+	routePattern = regexp.MustCompile(`\b(?:path|redirectTo)\s*[:=]\s*["'` + "`" + `]([^"'` + "`" + `\s]*)["'` + "`" + `]`)
+	// routeParam matches route parameters, e.g. :id
+	routeParam = regexp.MustCompile(`^:.+$`)
+	// routeChars matches what a route may be made of
+	routeChars = regexp.MustCompile(`^[\w\-./:*~%]*$`)
+)
+
+// isJavaScript reports whether a response is a JavaScript file
+func isJavaScript(r *colly.Response) bool {
+	if mediaType, _, err := mime.ParseMediaType(r.Headers.Get("Content-Type")); err == nil {
+		for _, t := range jsContentTypes {
+			if mediaType == t {
+				return true
+			}
+		}
+	}
+	return strings.EqualFold(path.Ext(r.Request.URL.Path), ".js")
+}
+
+// jsRoutes extracts the client-side routes defined in a JavaScript file. Route parameters are
+// filled in with 1 and wildcards dropped, so that the routes can be visited.
+func jsRoutes(js string) []string {
+	var routes []string
+	seen := make(map[string]bool)
+	for _, m := range routePattern.FindAllStringSubmatch(js, -1) {
+		route := m[1]
+		if route == "" || !routeChars.MatchString(route) || strings.Contains(route, "://") || path.Ext(route) != "" {
+			continue
+		}
+		var segments []string
+		for _, segment := range strings.Split(strings.Trim(route, "/"), "/") {
+			if routeParam.MatchString(segment) {
+				segment = "1"
+			}
+			if segment != "" && !strings.Contains(segment, "*") {
+				segments = append(segments, segment)
+			}
+		}
+		if segments == nil {
+			continue
+		}
+		route = "/" + strings.Join(segments, "/")
+		if !seen[route] {
+			seen[route] = true
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+var (
+	// chunkPattern matches how webpack runtimes build chunk file names, e.g.
+	// "static/js/"+({12:"about"}[e]||e)+"."+{12:"3f2a9c1b",34:"9e8d7c6b"}[e]+".chunk.js"
+	chunkPattern = regexp.MustCompile(`["']([^"'\s]*)["']\s*\+\s*(?:\(\s*\{([^{}]*)\}\s*\[\s*\w+\s*\]\s*\|\|\s*\w+\s*\)|\w+)\s*\+\s*["']\.["']\s*\+\s*\{([^{}]*)\}\s*\[\s*\w+\s*\]\s*\+\s*["']([^"'\s]*\.js)["']`)
+	// chunkMapEntry matches an entry of an object literal mapping chunk IDs to names or hashes
+	chunkMapEntry = regexp.MustCompile(`(\w+|"[^"]*"|'[^']*')\s*:\s*("[^"]*"|'[^']*')`)
+	// publicPathPattern matches the assignment of webpack's public path, e.g. __webpack_require__.p="/"
+	publicPathPattern = regexp.MustCompile(`\.p\s*=\s*["']([^"']*)["']`)
+)
+
+// webpackChunks lists the chunk files a webpack runtime can load, as paths relative to the site
+// root. It returns nil for JavaScript files that are not webpack runtimes.
+func webpackChunks(js string) []string {
+	publicPath := "/"
+	if m := publicPathPattern.FindStringSubmatch(js); m != nil && strings.HasPrefix(m[1], "/") {
+		publicPath = m[1]
+	}
+	var chunks []string
+	for _, m := range chunkPattern.FindAllStringSubmatch(js, -1) {
+		names := parseChunkMap(m[2])
+		for id, hash := range parseChunkMap(m[3]) {
+			name := id
+			if n, ok := names[id]; ok {
+				name = n
+			}
+			chunks = append(chunks, publicPath+m[1]+name+"."+hash+m[4])
+		}
+	}
+	sort.Strings(chunks)
+	return chunks
+}
+
+// parseChunkMap parses the entries of an object literal like {12:"about","34":"contact"}
+func parseChunkMap(literal string) map[string]string {
+	entries := make(map[string]string)
+	for _, m := range chunkMapEntry.FindAllStringSubmatch(literal, -1) {
+		entries[strings.Trim(m[1], `"'`)] = strings.Trim(m[2], `"'`)
+	}
+	return entries
+}
+
+// assetManifestFiles lists the files of a webpack asset manifest, such as the asset-manifest.json
+// of Create React App, whatever their nesting
+func assetManifestFiles(manifest interface{}) []string {
+	var files []string
+	switch v := manifest.(type) {
+	case map[string]interface{}:
+		for _, value := range v {
+			files = append(files, assetManifestFiles(value)...)
+		}
+	case []interface{}:
+		for _, value := range v {
+			files = append(files, assetManifestFiles(value)...)
+		}
+	case string:
+		if ext := path.Ext(v); ext == ".js" || ext == ".css" {
+			files = append(files, v)
+		}
+	}
+	return files
+}
+
+var (
+	// swRegisterPattern matches service worker registrations, e.g. navigator.serviceWorker.register("/sw.js")
+	swRegisterPattern = regexp.MustCompile(`serviceWorker\s*\.\s*register\s*\(\s*["'` + "`" + `]([^"'` + "`" + `]+)["'` + "`" + `]`)
+	// importScriptsPattern matches the argument list of importScripts calls in workers
+	importScriptsPattern = regexp.MustCompile(`importScripts\s*\(([^)]*)\)`)
+	// cacheListPattern matches the lists of URLs cached by service workers, e.g. cache.addAll([...])
+	// or workbox's precacheAndRoute([...])
+	cacheListPattern = regexp.MustCompile(`(?:addAll|precacheAndRoute|precache)\s*\(\s*\[([^\]]*)\]`)
+	// registerRoutePattern matches workbox routes given as strings, e.g. registerRoute("/api/items", ...)
+	registerRoutePattern = regexp.MustCompile(`registerRoute\s*\(\s*["'` + "`" + `]([^"'` + "`" + `]+)["'` + "`" + `]`)
+	// stringLiteral matches a quoted JavaScript string
+	stringLiteral = regexp.MustCompile(`["'` + "`" + `]([^"'` + "`" + `]+)["'` + "`" + `]`)
+	// precacheEntry matches the URL of an entry in a workbox precache manifest, e.g. {url:"/index.html",revision:"..."}
+	precacheEntry = regexp.MustCompile(`\burl\s*:\s*["'` + "`" + `]([^"'` + "`" + `]+)["'` + "`" + `]`)
+)
+
+// serviceWorkerRefs holds what a script says about service workers: the workers it registers,
+// the scripts it imports, and the URLs it caches or routes
+type serviceWorkerRefs struct {
+	workers []string
+	imports []string
+	cached  []string
+}
+
+// serviceWorkerURLs extracts service worker related URLs from a script. They are relative to the script.
+func serviceWorkerURLs(js string) serviceWorkerRefs {
+	var refs serviceWorkerRefs
+	for _, m := range swRegisterPattern.FindAllStringSubmatch(js, -1) {
+		refs.workers = append(refs.workers, m[1])
+	}
+	for _, m := range importScriptsPattern.FindAllStringSubmatch(js, -1) {
+		for _, s := range stringLiteral.FindAllStringSubmatch(m[1], -1) {
+			refs.imports = append(refs.imports, s[1])
+		}
+	}
+	for _, m := range cacheListPattern.FindAllStringSubmatch(js, -1) {
+		entries := precacheEntry.FindAllStringSubmatch(m[1], -1)
+		if entries == nil {
+			entries = stringLiteral.FindAllStringSubmatch(m[1], -1)
+		}
+		for _, s := range entries {
+			refs.cached = append(refs.cached, s[1])
+		}
+	}
+	for _, m := range registerRoutePattern.FindAllStringSubmatch(js, -1) {
+		refs.cached = append(refs.cached, m[1])
+	}
+	return refs
+}
+
+var (
+	// uploadAPIPattern matches quoted URLs and paths that look like upload endpoints, e.g. "/api/files/upload"
+	uploadAPIPattern = regexp.MustCompile(`(?i)["'` + "`" + `]((?:https?://[^"'` + "`" + `\s/]+)?/[^"'` + "`" + `\s]*(?:upload|attachment|import|avatar)[^"'` + "`" + `\s]*)["'` + "`" + `]`)
+	// multipartPattern matches code building multipart request bodies
+	multipartPattern = regexp.MustCompile(`new\s+FormData\b|multipart/form-data`)
+)
+
+// uploadEndpoints extracts the endpoints a script looks to upload files to. Scripts that never
+// build a multipart body are skipped, as the paths they mention are then unlikely to take files.
+func uploadEndpoints(js string) []string {
+	if !multipartPattern.MatchString(js) {
+		return nil
+	}
+	var endpoints []string
+	seen := make(map[string]bool)
+	for _, m := range uploadAPIPattern.FindAllStringSubmatch(js, -1) {
+		if path.Ext(strings.SplitN(m[1], "?", 2)[0]) == ".js" || seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		endpoints = append(endpoints, m[1])
+	}
+	return endpoints
+}
+
+var (
+	// concatPattern matches string concatenations of literals and identifiers, e.g. baseUrl + "/api/v2/" + resource
+	concatPattern = regexp.MustCompile(`(?:(?:"[^"\n]*"|'[^'\n]*'|[\w$.]+)\s*\+\s*)+(?:"[^"\n]*"|'[^'\n]*'|[\w$.]+)`)
+	// concatOperand matches one operand of a concatenation
+	concatOperand = regexp.MustCompile(`"([^"\n]*)"|'([^'\n]*)'|[\w$.]+`)
+	// templateLiteral matches template literals with substitutions, e.g. ` + "`${api}/users/${id}`" + `
+	templateLiteral = regexp.MustCompile("`([^`\\n]*\\$\\{[^`\\n]*)`")
+	// substitution matches a template literal substitution
+	substitution = regexp.MustCompile(`\$\{[^}]*\}`)
+	// endpointChars matches what a reconstructed endpoint may be made of
+	endpointChars = regexp.MustCompile(`^(?:https?://[\w\-.:]+)?/[\w\-./~%?=&]*$`)
+)
+
+// jsEndpoints reconstructs the endpoints built by concatenating strings and variables, or with
+// template literals, e.g. baseUrl + "/api/v2/" + resource. Variables before the first path
+// are taken to be the base URL and dropped, the others are filled in with 1, like route parameters.
+func jsEndpoints(js string) []string {
+	var endpoints []string
+	seen := make(map[string]bool)
+	add := func(parts []string, literal []bool) {
+		var endpoint strings.Builder
+		for i, part := range parts {
+			switch {
+			case literal[i]:
+				endpoint.WriteString(part)
+			case endpoint.Len() > 0:
+				endpoint.WriteString("1")
+			}
+		}
+		e := endpoint.String()
+		if !endpointChars.MatchString(e) || !strings.ContainsAny(strings.TrimPrefix(e, "/"), "/?") || path.Ext(strings.SplitN(e, "?", 2)[0]) == ".js" {
+			return
+		}
+		if !seen[e] {
+			seen[e] = true
+			endpoints = append(endpoints, e)
+		}
+	}
+
+	for _, chain := range concatPattern.FindAllString(js, -1) {
+		var parts []string
+		var literal []bool
+		hasLiteral := false
+		for _, m := range concatOperand.FindAllStringSubmatch(chain, -1) {
+			switch {
+			case strings.HasPrefix(m[0], `"`):
+				parts, literal, hasLiteral = append(parts, m[1]), append(literal, true), true
+			case strings.HasPrefix(m[0], "'"):
+				parts, literal, hasLiteral = append(parts, m[2]), append(literal, true), true
+			default:
+				parts, literal = append(parts, m[0]), append(literal, false)
+			}
+		}
+		if hasLiteral {
+			add(parts, literal)
+		}
+	}
+	for _, m := range templateLiteral.FindAllStringSubmatch(js, -1) {
+		var parts []string
+		var literal []bool
+		last := 0
+		for _, loc := range substitution.FindAllStringIndex(m[1], -1) {
+			parts, literal = append(parts, m[1][last:loc[0]], ""), append(literal, true, false)
+			last = loc[1]
+		}
+		parts, literal = append(parts, m[1][last:]), append(literal, true)
+		add(parts, literal)
+	}
+	return endpoints
+}
+
+// jsParser extracts client-side routes, API endpoints, webpack chunks, service workers and
+// upload endpoints from JavaScript files, and the files of webpack asset manifests. Inline
+// scripts are parsed by an OnHTML callback set up in main.
+type jsParser struct{}
+
+func (jsParser) Handles(r *colly.Response) bool {
+	return isJavaScript(r) || path.Base(r.Request.URL.Path) == "asset-manifest.json"
+}
+
+func (jsParser) Parse(r *colly.Response) []ParsedLink {
+	var links []ParsedLink
+	if path.Base(r.Request.URL.Path) == "asset-manifest.json" {
+		var manifest interface{}
+		if json.Unmarshal(r.Body, &manifest) != nil {
+			return nil
+		}
+		for _, file := range assetManifestFiles(manifest) {
+			links = append(links, ParsedLink{Source: "chunk", URL: r.Request.AbsoluteURL(file), Visit: true})
+		}
+		return links
+	}
+
+	js := string(r.Body)
+	links = jsLinks(js, r.Request, loadBase(&scriptBases, r.Request.URL.String()))
+	// fetch every chunk of webpack runtimes, and look for the asset manifest next to them
+	if chunks := webpackChunks(js); chunks != nil {
+		origin := r.Request.URL.Scheme + "://" + r.Request.URL.Host
+		for _, chunk := range chunks {
+			links = append(links, ParsedLink{Source: "chunk", URL: origin + chunk, Visit: true})
+		}
+		links = append(links, ParsedLink{URL: origin + "/asset-manifest.json", Visit: true})
+	}
+	return links
+}
+
+// jsLinks returns the links a script holds. base is the <base href> of the page running the
+// script, or "".
+func jsLinks(js string, r *colly.Request, base string) []ParsedLink {
+	var links []ParsedLink
+	origin := r.URL.Scheme + "://" + r.URL.Host
+	for _, route := range jsRoutes(js) {
+		link := origin + route
+		// routers take their routes to be relative to the base, e.g. Angular apps under /app/
+		if base != "" {
+			link = resolveAgainst(r, base, strings.TrimPrefix(route, "/"))
+		}
+		links = append(links, ParsedLink{Source: "route", URL: link, Visit: true})
+	}
+	for _, endpoint := range jsEndpoints(js) {
+		link := resolveAgainst(r, base, endpoint)
+		if base == "" && strings.HasPrefix(endpoint, "/") {
+			link = origin + endpoint
+		}
+		links = append(links, ParsedLink{Source: "endpoint", URL: link, Visit: true})
+	}
+	// service workers and the scripts they import are fetched to be parsed in turn.
+	// Workers are registered relative to the page, but import and cache relative to themselves.
+	sw := serviceWorkerURLs(js)
+	for _, link := range sw.workers {
+		links = append(links, ParsedLink{Source: "serviceworker", URL: resolveAgainst(r, base, link), Visit: true})
+	}
+	for _, link := range sw.imports {
+		links = append(links, ParsedLink{Source: "serviceworker", URL: r.AbsoluteURL(link), Visit: true})
+	}
+	for _, link := range sw.cached {
+		links = append(links, ParsedLink{Source: "serviceworker", URL: r.AbsoluteURL(link)})
+	}
+	for _, link := range uploadEndpoints(js) {
+		links = append(links, ParsedLink{Source: "upload", URL: resolveAgainst(r, base, link)})
+	}
+	return links
+}
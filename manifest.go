@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Web app manifest URLs found in pages, so their responses can be recognised
+var manifests sync.Map
+
+// webAppManifest is the part of a web app manifest that holds URLs
+type webAppManifest struct {
+	StartURL string `json:"start_url"`
+	Scope    string `json:"scope"`
+	Icons    []struct {
+		Src string `json:"src"`
+	} `json:"icons"`
+	Screenshots []struct {
+		Src string `json:"src"`
+	} `json:"screenshots"`
+	Shortcuts []struct {
+		URL   string `json:"url"`
+		Icons []struct {
+			Src string `json:"src"`
+		} `json:"icons"`
+	} `json:"shortcuts"`
+}
+
+// manifestURLs lists the URLs of a web app manifest, relative to the manifest itself
+func manifestURLs(body []byte) ([]string, error) {
+	var manifest webAppManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, u := range []string{manifest.StartURL, manifest.Scope} {
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	for _, icon := range manifest.Icons {
+		urls = append(urls, icon.Src)
+	}
+	for _, screenshot := range manifest.Screenshots {
+		urls = append(urls, screenshot.Src)
+	}
+	for _, shortcut := range manifest.Shortcuts {
+		urls = append(urls, shortcut.URL)
+		for _, icon := range shortcut.Icons {
+			urls = append(urls, icon.Src)
+		}
+	}
+	return urls, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NTLM negotiate flags, see MS-NLMP 2.2.2.5
+const (
+	ntlmNegotiateUnicode           = 0x00000001
+	ntlmRequestTarget              = 0x00000004
+	ntlmNegotiateNTLM              = 0x00000200
+	ntlmNegotiateAlwaysSign        = 0x00008000
+	ntlmNegotiateExtendedSecurity  = 0x00080000
+	ntlmNegotiateTargetInfo        = 0x00800000
+	ntlmNegotiate128               = 0x20000000
+	ntlmNegotiate56                = 0x80000000
+	ntlmDefaultFlags               = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSecurity | ntlmNegotiateTargetInfo | ntlmNegotiate128 | ntlmNegotiate56
+	ntlmSignature                  = "NTLMSSP\x00"
+	ntlmWindowsEpochOffsetInTenths = 116444736000000000
+)
+
+// ntlmTransport answers NTLM challenges with NTLMv2 credentials. The handshake relies on the
+// underlying transport reusing the same keep-alive connection for all of its legs.
+type ntlmTransport struct {
+	next     http.RoundTripper
+	domain   string
+	username string
+	password string
+}
+
+// newNTLMTransport parses "DOMAIN\user:pass" credentials, the domain being optional
+func newNTLMTransport(next http.RoundTripper, credentials string) (*ntlmTransport, error) {
+	parts := strings.SplitN(credentials, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("credentials not formatted properly (expected DOMAIN\\user:pass)")
+	}
+	t := &ntlmTransport{next: next, username: parts[0], password: parts[1]}
+	if i := strings.Index(parts[0], "\\"); i != -1 {
+		t.domain, t.username = parts[0][:i], parts[0][i+1:]
+	}
+	return t, nil
+}
+
+func (t *ntlmTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	scheme := "NTLM"
+	if !hasChallenge(resp, scheme) {
+		scheme = "Negotiate"
+		if !hasChallenge(resp, scheme) {
+			return resp, nil
+		}
+	}
+
+	// send the negotiate message and read the server's challenge from its answer
+	negotiate, err := rewindRequest(req)
+	if err != nil {
+		return resp, nil
+	}
+	drainBody(resp)
+	negotiate.Header.Set("Authorization", scheme+" "+base64.StdEncoding.EncodeToString(ntlmNegotiateMessage()))
+	resp, err = t.next.RoundTrip(negotiate)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	var challenge []byte
+	for _, value := range resp.Header.Values("WWW-Authenticate") {
+		if strings.HasPrefix(value, scheme+" ") {
+			challenge, _ = base64.StdEncoding.DecodeString(strings.TrimSpace(value[len(scheme)+1:]))
+		}
+	}
+	authenticate, err := t.authenticateMessage(challenge)
+	if err != nil {
+		return resp, nil
+	}
+
+	// send the actual request along with the answer to the challenge
+	retry, err := rewindRequest(req)
+	if err != nil {
+		return resp, nil
+	}
+	drainBody(resp)
+	retry.Header.Set("Authorization", scheme+" "+base64.StdEncoding.EncodeToString(authenticate))
+	return t.next.RoundTrip(retry)
+}
+
+// drainBody reads a response body to the end so its connection can be reused
+func drainBody(resp *http.Response) {
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// ntlmNegotiateMessage builds the first message of the handshake
+func ntlmNegotiateMessage() []byte {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmDefaultFlags)
+	return msg
+}
+
+// authenticateMessage builds the NTLMv2 answer to a challenge message
+func (t *ntlmTransport) authenticateMessage(challenge []byte) ([]byte, error) {
+	if len(challenge) < 48 || string(challenge[:8]) != ntlmSignature || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, errors.New("invalid NTLM challenge message")
+	}
+	flags := binary.LittleEndian.Uint32(challenge[20:])
+	serverChallenge := challenge[24:32]
+	targetInfo, err := ntlmField(challenge, 40)
+	if err != nil {
+		return nil, err
+	}
+
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, err
+	}
+	timestamp := make([]byte, 8)
+	binary.LittleEndian.PutUint64(timestamp, uint64(time.Now().UnixNano()/100+ntlmWindowsEpochOffsetInTenths))
+
+	hash := ntowfv2(t.username, t.password, t.domain)
+	var temp bytes.Buffer
+	temp.Write([]byte{1, 1, 0, 0, 0, 0, 0, 0})
+	temp.Write(timestamp)
+	temp.Write(clientChallenge)
+	temp.Write([]byte{0, 0, 0, 0})
+	temp.Write(targetInfo)
+	temp.Write([]byte{0, 0, 0, 0})
+	ntProof := hmacMD5(hash, serverChallenge, temp.Bytes())
+	ntResponse := append(ntProof, temp.Bytes()...)
+	lmResponse := append(hmacMD5(hash, serverChallenge, clientChallenge), clientChallenge...)
+
+	encode := func(s string) []byte { return []byte(s) }
+	if flags&ntlmNegotiateUnicode != 0 {
+		encode = utf16le
+	}
+	payloads := [][]byte{lmResponse, ntResponse, encode(t.domain), encode(t.username), encode(""), nil}
+	msg := make([]byte, 64)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	offset := len(msg)
+	for i, payload := range payloads {
+		field := msg[12+8*i:]
+		binary.LittleEndian.PutUint16(field, uint16(len(payload)))
+		binary.LittleEndian.PutUint16(field[2:], uint16(len(payload)))
+		binary.LittleEndian.PutUint32(field[4:], uint32(offset))
+		offset += len(payload)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], flags&ntlmDefaultFlags)
+	for _, payload := range payloads {
+		msg = append(msg, payload...)
+	}
+	return msg, nil
+}
+
+// ntlmField returns the payload of the security buffer found at the given offset of a message
+func ntlmField(msg []byte, at int) ([]byte, error) {
+	length := int(binary.LittleEndian.Uint16(msg[at:]))
+	offset := int(binary.LittleEndian.Uint32(msg[at+4:]))
+	if offset+length > len(msg) {
+		return nil, errors.New("invalid NTLM message field")
+	}
+	return msg[offset : offset+length], nil
+}
+
+// ntowfv2 derives the NTLMv2 response key from the user's credentials
+func ntowfv2(username, password, domain string) []byte {
+	return hmacMD5(md4Sum(utf16le(password)), utf16le(strings.ToUpper(username)+domain))
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+func utf16le(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(encoded))
+	for i, r := range encoded {
+		binary.LittleEndian.PutUint16(b[2*i:], r)
+	}
+	return b
+}
+
+// md4Sum implements RFC 1320, which NTLM still needs to hash passwords
+func md4Sum(data []byte) []byte {
+	length := uint64(len(data)) * 8
+	msg := append([]byte{}, data...)
+	msg = append(msg, 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = append(msg, make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(msg[len(msg)-8:], length)
+
+	rotl := func(x uint32, s uint) uint32 { return x<<s | x>>(32-s) }
+	state := [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+	rounds := []struct {
+		order  [16]int
+		shifts [4]uint
+		add    uint32
+		f      func(x, y, z uint32) uint32
+	}{
+		{[16]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, [4]uint{3, 7, 11, 19}, 0, func(x, y, z uint32) uint32 { return x&y | ^x&z }},
+		{[16]int{0, 4, 8, 12, 1, 5, 9, 13, 2, 6, 10, 14, 3, 7, 11, 15}, [4]uint{3, 5, 9, 13}, 0x5a827999, func(x, y, z uint32) uint32 { return x&y | x&z | y&z }},
+		{[16]int{0, 8, 4, 12, 2, 10, 6, 14, 1, 9, 5, 13, 3, 11, 7, 15}, [4]uint{3, 9, 11, 15}, 0x6ed9eba1, func(x, y, z uint32) uint32 { return x ^ y ^ z }},
+	}
+	var x [16]uint32
+	for block := 0; block < len(msg); block += 64 {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(msg[block+4*i:])
+		}
+		s := state
+		for _, round := range rounds {
+			for i, k := range round.order {
+				// each step updates a, d, c and b in turn
+				a, b, c, d := &s[(4-i%4)%4], s[(5-i%4)%4], s[(6-i%4)%4], s[(7-i%4)%4]
+				*a = rotl(*a+round.f(b, c, d)+x[k]+round.add, round.shifts[i%4])
+			}
+		}
+		for i := range state {
+			state[i] += s[i]
+		}
+	}
+	sum := make([]byte, 16)
+	for i, v := range state {
+		binary.LittleEndian.PutUint32(sum[4*i:], v)
+	}
+	return sum
+}
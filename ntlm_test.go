@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func TestMD4Sum(t *testing.T) {
+	// the test suite of RFC 1320
+	tests := []struct {
+		input string
+		sum   string
+	}{
+		{"", "31d6cfe0d16ae931b73c59d7e0c089c0"},
+		{"a", "bde52cb31de33e46245e05fbdbd6fb24"},
+		{"abc", "a448017aaf21d8525fc10ae87aa6729d"},
+		{"message digest", "d9130a8164549fe818874806e1c7014b"},
+		{"abcdefghijklmnopqrstuvwxyz", "d79e1c308aa5bbcdeea8ed63df412da9"},
+		{"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789", "043f8582f241db351ce627e153e7f0e4"},
+		{"12345678901234567890123456789012345678901234567890123456789012345678901234567890", "e33b4ddc9c38f2199c3e7b164fcc0536"},
+	}
+	for _, test := range tests {
+		if sum := hex.EncodeToString(md4Sum([]byte(test.input))); sum != test.sum {
+			t.Errorf("md4Sum(%q) = %s, want %s", test.input, sum, test.sum)
+		}
+	}
+}
+
+// the NTLMv2 example of MS-NLMP 4.2.4
+var (
+	ntlmTestServerChallenge, _ = hex.DecodeString("0123456789abcdef")
+	ntlmTestClientChallenge, _ = hex.DecodeString("aaaaaaaaaaaaaaaa")
+	// MsvAvNbDomainName "Domain", MsvAvNbComputerName "Server" and MsvAvEOL
+	ntlmTestTargetInfo, _ = hex.DecodeString("02000c0044006f006d00610069006e00" + "01000c00530065007200760065007200" + "00000000")
+)
+
+func TestNTOWFv2(t *testing.T) {
+	tests := []struct {
+		username, password, domain string
+		key                        string
+	}{
+		{"User", "Password", "Domain", "0c868a403bfd7a93a3001ef22ef02e3f"},
+		// only the user name is uppercased
+		{"user", "Password", "Domain", "0c868a403bfd7a93a3001ef22ef02e3f"},
+	}
+	for _, test := range tests {
+		if key := hex.EncodeToString(ntowfv2(test.username, test.password, test.domain)); key != test.key {
+			t.Errorf("ntowfv2(%q, %q, %q) = %s, want %s", test.username, test.password, test.domain, key, test.key)
+		}
+	}
+}
+
+func TestNTLMv2Responses(t *testing.T) {
+	key := ntowfv2("User", "Password", "Domain")
+	var temp bytes.Buffer
+	temp.Write([]byte{1, 1, 0, 0, 0, 0, 0, 0})
+	temp.Write(make([]byte, 8))
+	temp.Write(ntlmTestClientChallenge)
+	temp.Write([]byte{0, 0, 0, 0})
+	temp.Write(ntlmTestTargetInfo)
+	temp.Write([]byte{0, 0, 0, 0})
+
+	tests := []struct {
+		name     string
+		response []byte
+		want     string
+	}{
+		{"NTProofStr", hmacMD5(key, ntlmTestServerChallenge, temp.Bytes()), "68cd0ab851e51c96aabc927bebef6a1c"},
+		{"LMv2", hmacMD5(key, ntlmTestServerChallenge, ntlmTestClientChallenge), "86c35097ac9cec102554764a57cccc19"},
+	}
+	for _, test := range tests {
+		if got := hex.EncodeToString(test.response); got != test.want {
+			t.Errorf("%s = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAuthenticateMessage(t *testing.T) {
+	challenge := make([]byte, 48)
+	copy(challenge, ntlmSignature)
+	binary.LittleEndian.PutUint32(challenge[8:], 2)
+	binary.LittleEndian.PutUint32(challenge[20:], ntlmNegotiateUnicode)
+	copy(challenge[24:], ntlmTestServerChallenge)
+	binary.LittleEndian.PutUint16(challenge[40:], uint16(len(ntlmTestTargetInfo)))
+	binary.LittleEndian.PutUint32(challenge[44:], uint32(len(challenge)))
+	challenge = append(challenge, ntlmTestTargetInfo...)
+
+	transport := &ntlmTransport{username: "User", password: "Password", domain: "Domain"}
+	msg, err := transport.authenticateMessage(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg[:8]) != ntlmSignature || binary.LittleEndian.Uint32(msg[8:]) != 3 {
+		t.Fatalf("not an authenticate message: %x", msg[:12])
+	}
+
+	// the client challenge and timestamp are random, so the proof is checked against the blob sent
+	ntResponse, err := ntlmField(msg, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := ntowfv2("User", "Password", "Domain")
+	if proof := hmacMD5(key, ntlmTestServerChallenge, ntResponse[16:]); !bytes.Equal(proof, ntResponse[:16]) {
+		t.Errorf("NTProofStr = %x, want %x", ntResponse[:16], proof)
+	}
+	if !bytes.Contains(ntResponse, ntlmTestTargetInfo) {
+		t.Error("NT response does not hold the target info")
+	}
+
+	fields := []struct {
+		name string
+		at   int
+		want []byte
+	}{
+		{"domain", 28, utf16le("Domain")},
+		{"user", 36, utf16le("User")},
+		{"workstation", 44, nil},
+	}
+	for _, field := range fields {
+		value, err := ntlmField(msg, field.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, field.want) {
+			t.Errorf("%s = %x, want %x", field.name, value, field.want)
+		}
+	}
+
+	if _, err := transport.authenticateMessage(challenge[:40]); err == nil {
+		t.Error("short challenge accepted")
+	}
+}
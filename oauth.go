@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oauthTransport adds a bearer token obtained with the OAuth2 client credentials grant to every
+// request, fetching a new one shortly before the current one expires.
+type oauthTransport struct {
+	next         http.RoundTripper
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scope        string
+	mu           sync.Mutex
+	accessToken  string
+	expiry       time.Time
+}
+
+func (t *oauthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.token()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.next.RoundTrip(req)
+}
+
+// token returns the current access token, requesting a new one if it is missing or about to expire
+func (t *oauthTransport) token() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.accessToken != "" && (t.expiry.IsZero() || time.Now().Add(30*time.Second).Before(t.expiry)) {
+		return t.accessToken, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if t.scope != "" {
+		form.Set("scope", t.scope)
+	}
+	req, err := http.NewRequest("POST", t.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(t.clientID), url.QueryEscape(t.clientSecret))
+	resp, err := (&http.Client{Transport: t.next}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed with status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.AccessToken == "" {
+		return "", fmt.Errorf("no access token in token response")
+	}
+	t.accessToken = body.AccessToken
+	t.expiry = time.Time{}
+	if body.ExpiresIn > 0 {
+		t.expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return t.accessToken, nil
+}
+
+// jwtTransport keeps a JWT bearer token fresh by running a command for a new one shortly before
+// the current one expires. Without a command, it warns once the token has expired.
+type jwtTransport struct {
+	next    http.RoundTripper
+	command []string
+	mu      sync.Mutex
+	token   string
+	expiry  time.Time
+	warned  bool
+}
+
+// newJWTTransport starts from the given token, or asks the command for one if it is empty
+func newJWTTransport(next http.RoundTripper, token string, command string) (*jwtTransport, error) {
+	t := &jwtTransport{next: next, command: strings.Fields(command), token: token, expiry: jwtExpiry(token)}
+	if t.token == "" {
+		if err := t.refresh(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *jwtTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.current())
+	return t.next.RoundTrip(req)
+}
+
+// current returns the token to use, refreshing it when it expires within a minute
+func (t *jwtTransport) current() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.expiry.IsZero() || time.Now().Add(time.Minute).Before(t.expiry) {
+		return t.token
+	}
+	if len(t.command) > 0 {
+		if err := t.refresh(); err != nil {
+			log.Println("Error refreshing JWT:", err)
+		}
+	} else if !t.warned && time.Now().After(t.expiry) {
+		log.Println("JWT expired at", t.expiry.Format(time.RFC3339)+", use -token-cmd to refresh it automatically")
+		t.warned = true
+	}
+	return t.token
+}
+
+// forceRefresh gets a new token even if the current one has not expired
+func (t *jwtTransport) forceRefresh() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.refresh()
+}
+
+// refresh runs the token command and takes its output as the new token
+func (t *jwtTransport) refresh() error {
+	if len(t.command) == 0 {
+		return fmt.Errorf("no token command")
+	}
+	out, err := exec.Command(t.command[0], t.command[1:]...).Output()
+	if err != nil {
+		return err
+	}
+	t.token = strings.TrimPrefix(strings.TrimSpace(string(out)), "Bearer ")
+	t.expiry = jwtExpiry(t.token)
+	return nil
+}
+
+// jwtExpiry returns the time in the exp claim of a JWT, or the zero time if there is none
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp float64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(claims.Exp), 0)
+}
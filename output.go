@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// hostFiles writes results to one file per hostname in a directory, for -output-dir
+type hostFiles struct {
+	dir   string
+	mu    sync.Mutex
+	files map[string]*os.File
+	bufs  map[string]*bufio.Writer
+}
+
+func newHostFiles(dir string) (*hostFiles, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &hostFiles{
+		dir:   dir,
+		files: make(map[string]*os.File),
+		bufs:  make(map[string]*bufio.Writer),
+	}, nil
+}
+
+// write appends a result line to the file of its hostname, creating it on first use
+func (h *hostFiles) write(hostname string, line string) error {
+	if hostname == "" {
+		hostname = "_"
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	buf, ok := h.bufs[hostname]
+	if !ok {
+		name := unsafeFilename.ReplaceAllString(hostname, "_") + ".txt"
+		file, err := os.OpenFile(filepath.Join(h.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		buf = bufio.NewWriter(file)
+		h.files[hostname] = file
+		h.bufs[hostname] = buf
+	}
+	_, err := buf.WriteString(line + "\n")
+	return err
+}
+
+// close flushes and closes every file
+func (h *hostFiles) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for hostname, file := range h.files {
+		h.bufs[hostname].Flush()
+		file.Close()
+	}
+}
+
+// gzipFile is a gzip stream written to a file, closing both when closed
+type gzipFile struct {
+	*gzip.Writer
+	file *os.File
+}
+
+func (g gzipFile) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		g.file.Close()
+		return err
+	}
+	return g.file.Close()
+}
+
+// createOutput creates a file to write results to, compressed with gzip if its name ends in .gz
+func createOutput(filename string) (io.WriteCloser, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(filename, ".gz") {
+		return gzipFile{gzip.NewWriter(file), file}, nil
+	}
+	return file, nil
+}
+
+// errorLog records failed requests as JSON lines, for -error-log
+type errorLog struct {
+	mu       sync.Mutex
+	encoder  *json.Encoder
+	attempts map[string]int
+}
+
+// failedRequest is a line of the error log
+type failedRequest struct {
+	URL      string `json:"url"`
+	Method   string `json:"method"`
+	Status   int    `json:"status,omitempty"`
+	Type     string `json:"type"`
+	Error    string `json:"error"`
+	Attempts int    `json:"attempts"`
+}
+
+func newErrorLog(w io.Writer) *errorLog {
+	return &errorLog{encoder: json.NewEncoder(w), attempts: make(map[string]int)}
+}
+
+// record writes a failed request, counting how many times its URL has failed so far
+func (l *errorLog) record(r *colly.Response, err error) {
+	failure := failedRequest{
+		URL:    r.Request.URL.String(),
+		Method: r.Request.Method,
+		Status: r.StatusCode,
+		Type:   errorType(r, err),
+		Error:  err.Error(),
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attempts[failure.Method+" "+failure.URL]++
+	failure.Attempts = l.attempts[failure.Method+" "+failure.URL]
+	l.encoder.Encode(failure)
+}
+
+// errorType classifies why a request failed: http for error status codes, or timeout, dns,
+// refused, reset, tls or other for requests that got no response
+func errorType(r *colly.Response, err error) string {
+	if r.StatusCode != 0 {
+		return "http"
+	}
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	message := strings.ToLower(err.Error())
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case strings.Contains(message, "connection refused"):
+		return "refused"
+	case strings.Contains(message, "connection reset") || strings.Contains(message, "eof"):
+		return "reset"
+	case strings.Contains(message, "tls") || strings.Contains(message, "x509") || strings.Contains(message, "certificate"):
+		return "tls"
+	}
+	return "other"
+}
+
+// transientFailure reports whether a failed request is worth trying again later
+func transientFailure(r *colly.Response, err error) bool {
+	switch r.StatusCode {
+	case 0:
+		switch errorType(r, err) {
+		case "timeout", "refused", "reset", "other":
+			return true
+		}
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateOutput(t *testing.T) {
+	dir := t.TempDir()
+	const lines = "https://example.com/\nhttps://example.com/about\n"
+	for _, name := range []string{"results.txt", "results.txt.gz"} {
+		filename := filepath.Join(dir, name)
+		w, err := createOutput(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, lines); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		file, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = file
+		if filepath.Ext(name) == ".gz" {
+			if r, err = gzip.NewReader(file); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		data, err := io.ReadAll(r)
+		file.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(data) != lines {
+			t.Errorf("%s holds %q, want %q", name, data, lines)
+		}
+	}
+}
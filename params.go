@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// domainValue matches values that look like a host name, e.g. example.com or evil.example.org:8080/path,
+// with the top-level domain as its first group
+var domainValue = regexp.MustCompile(`(?i)^(?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+([a-z]{2,24})(?::\d+)?(?:/.*)?$`)
+
+// fileExtensions are the extensions of file names, which domainValue would otherwise take for
+// top-level domains, e.g. index.php or report.pdf
+var fileExtensions = map[string]bool{
+	"php": true, "html": true, "htm": true, "shtml": true, "asp": true, "aspx": true, "jsp": true, "do": true,
+	"action": true, "cgi": true, "pl": true, "py": true, "rb": true, "js": true, "css": true, "json": true,
+	"xml": true, "txt": true, "csv": true, "pdf": true, "doc": true, "docx": true, "xls": true, "xlsx": true,
+	"ppt": true, "pptx": true, "png": true, "jpg": true, "jpeg": true, "gif": true, "svg": true, "ico": true,
+	"webp": true, "mp3": true, "mp4": true, "avi": true, "woff": true, "woff2": true, "ttf": true, "tar": true,
+	"gz": true, "tgz": true, "rar": true, "exe": true, "bak": true, "log": true, "ini": true, "conf": true,
+}
+
+// isURLValue reports whether a parameter value holds a URL, a scheme-relative //host URL or a
+// domain name
+func isURLValue(value string) bool {
+	if strings.Contains(value, "://") || (strings.HasPrefix(value, "//") && len(value) > 2 && value[2] != '/') {
+		return true
+	}
+	m := domainValue.FindStringSubmatch(value)
+	return m != nil && !fileExtensions[strings.ToLower(m[1])]
+}
+
+// redirectParams returns the sorted names of the query parameters of a URL that hold another
+// URL or a domain. These are candidates for open redirects and SSRF.
+func redirectParams(link string) []string {
+	u, err := url.Parse(link)
+	if err != nil || u.RawQuery == "" {
+		return nil
+	}
+	var names []string
+	for name, values := range u.Query() {
+		for _, value := range values {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			if isURLValue(value) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reflectionCanary is the harmless value appended to parameters to see if they are reflected
+const reflectionCanary = "hkr4wl3rc4n4ry"
+
+// Parameters already probed for reflection, keyed by URL without query and parameter name
+var reflectionProbed sync.Map
+
+// probeReflection appends the canary to every query parameter of a crawled URL in turn, and
+// returns a result for each parameter reflected in the response, with where it was reflected.
+// Each parameter of a path is probed once.
+func probeReflection(client *http.Client, c *colly.Collector, r *colly.Request) []Result {
+	query := r.URL.Query()
+	base := *r.URL
+	base.RawQuery = ""
+	base.Fragment = ""
+
+	var found []Result
+	for name := range query {
+		if _, probed := reflectionProbed.LoadOrStore(base.String()+"\x00"+name, true); probed {
+			continue
+		}
+		probe := url.Values{}
+		for other, values := range query {
+			probe[other] = values
+		}
+		probe.Set(name, query.Get(name)+reflectionCanary)
+		u := base
+		u.RawQuery = probe.Encode()
+
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			continue
+		}
+		req.Header = r.Headers.Clone()
+		for _, cookie := range c.Cookies(u.String()) {
+			req.AddCookie(cookie)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			continue
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+		if contexts := reflectionContexts(resp.Header, body); contexts != nil {
+			found = append(found, Result{Source: "reflection", URL: u.String(), Parameter: name, Contexts: contexts})
+		}
+	}
+	return found
+}
+
+// reflectionContexts returns where the canary shows up in a response: in a header, inside a
+// script, inside a tag (usually an attribute value) or in the HTML text
+func reflectionContexts(header http.Header, body []byte) []string {
+	var contexts []string
+	seen := make(map[string]bool)
+	add := func(context string) {
+		if !seen[context] {
+			seen[context] = true
+			contexts = append(contexts, context)
+		}
+	}
+	for _, values := range header {
+		for _, value := range values {
+			if strings.Contains(value, reflectionCanary) {
+				add("header")
+			}
+		}
+	}
+	lower := bytes.ToLower(body)
+	offset := 0
+	for {
+		i := bytes.Index(body[offset:], []byte(reflectionCanary))
+		if i < 0 {
+			break
+		}
+		i += offset
+		before := lower[:i]
+		switch {
+		case bytes.LastIndex(before, []byte("<script")) > bytes.LastIndex(before, []byte("</script")):
+			add("script")
+		case bytes.LastIndexByte(before, '<') > bytes.LastIndexByte(before, '>'):
+			add("attribute")
+		default:
+			add("html")
+		}
+		offset = i + len(reflectionCanary)
+	}
+	return contexts
+}
+
+// severities are the severity levels of findings, lowest first
+var severities = []string{"info", "low", "medium", "high"}
+
+// severityLevel returns the rank of a severity in severities, unknown and empty ones counting as info
+func severityLevel(severity string) int {
+	for i, s := range severities {
+		if s == severity {
+			return i
+		}
+	}
+	return 0
+}
+
+// categorize sets the category and severity of the results that are findings rather than plain links
+func categorize(res *Result) {
+	switch {
+	case res.Source == "reflection":
+		res.Category, res.Severity = "reflection", "medium"
+		for _, context := range res.Contexts {
+			if context == "script" || context == "attribute" {
+				res.Severity = "high"
+			}
+		}
+	case res.Source == "upload":
+		res.Category, res.Severity = "upload", "medium"
+	case res.RedirectParams != nil:
+		res.Category, res.Severity = "open-redirect-candidate", "medium"
+	case res.Source == "regex" && res.Groups["secret"] != "":
+		res.Category, res.Severity = "secret", "high"
+	case res.Source == "email":
+		res.Category, res.Severity = "email", "info"
+	case res.Source == "match" || res.Source == "regex":
+		res.Category, res.Severity = "match", "info"
+	case res.Source == "cors" && res.AllowCredentials && (res.AllowOrigin == "*" || res.AllowOrigin == "null"):
+		res.Category, res.Severity = "cors-misconfiguration", "medium"
+	case res.Source == "xhr" || res.Source == "click-xhr" || res.Source == "endpoint":
+		res.Category, res.Severity = "endpoint", "info"
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Parser extracts links from the responses of the content types it handles. To add one, put
+// a file in this package that registers it from an init function, and enable it with -parsers:
+//
+//	func init() {
+//		registerParser("myparser", &myParser{})
+//	}
+type Parser interface {
+	// Handles reports whether the parser extracts links from a response. It is also called
+	// once the headers arrive, to download the response whatever -visit-mime says, so it must
+	// not look at the body.
+	Handles(r *colly.Response) bool
+	// Parse returns the links found in a response
+	Parse(r *colly.Response) []ParsedLink
+}
+
+// ParsedLink is a link found by a parser, as an absolute URL. Links without a source are
+// visited but not output.
+type ParsedLink struct {
+	Source string
+	URL    string
+	Visit  bool
+}
+
+// Parsers compiled in, by name
+var parsers = make(map[string]Parser)
+
+// Parsers enabled with -parsers, by name
+var activeParsers = make(map[string]Parser)
+
+// Whether the links of HTML pages are extracted. These are extracted by the OnHTML callbacks
+// set up in main rather than by a Parser, but are turned on and off with -parsers as html.
+var parseHTML bool
+
+// defaultParsers are the parsers enabled when -parsers is not given. Only the links of HTML pages
+// are extracted, as the parsers enabled also download their responses past -visit-mime.
+const defaultParsers = "html"
+
+// registerParser makes a parser available to -parsers under name
+func registerParser(name string, p Parser) {
+	if _, ok := parsers[name]; ok {
+		panic("parser registered twice: " + name)
+	}
+	parsers[name] = p
+}
+
+// enableParsers enables the comma-separated parsers in activeParsers
+func enableParsers(names string) error {
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if name == "html" {
+			parseHTML = true
+			continue
+		}
+		p, ok := parsers[name]
+		if !ok {
+			available := []string{"html"}
+			for known := range parsers {
+				available = append(available, known)
+			}
+			sort.Strings(available)
+			return fmt.Errorf("unknown parser %q, available: %s", name, strings.Join(available, ", "))
+		}
+		activeParsers[name] = p
+	}
+	return nil
+}
+
+// parserWants reports whether an enabled parser extracts links from a response, given its headers
+func parserWants(r *colly.Response) bool {
+	for _, p := range activeParsers {
+		if p.Handles(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	registerParser("js", jsParser{})
+	registerParser("css", cssParser{})
+	registerParser("json", jsonParser{})
+	registerParser("xml", xmlParser{})
+	registerParser("pdf", pdfParser{})
+}
+
+// mediaType returns the media type of a response, or "" if it has none
+func mediaType(r *colly.Response) string {
+	mediaType, _, err := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// cssParser extracts the url() references and imports of stylesheets. Imports are visited,
+// to be parsed in turn.
+type cssParser struct{}
+
+var (
+	// cssURL matches url() references, e.g. url("/img/bg.png")
+	cssURL = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^'")\s]*))\s*\)`)
+	// cssImport matches imports given as a string, e.g. @import "print.css"
+	cssImport = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+func (cssParser) Handles(r *colly.Response) bool {
+	return mediaType(r) == "text/css" || strings.EqualFold(path.Ext(r.Request.URL.Path), ".css")
+}
+
+func (cssParser) Parse(r *colly.Response) []ParsedLink {
+	var links []ParsedLink
+	for _, m := range cssImport.FindAllStringSubmatch(string(r.Body), -1) {
+		links = append(links, ParsedLink{Source: "css", URL: r.Request.AbsoluteURL(m[1] + m[2]), Visit: true})
+	}
+	for _, m := range cssURL.FindAllStringSubmatch(string(r.Body), -1) {
+		link := strings.TrimSpace(m[1] + m[2] + m[3])
+		if link == "" || pseudoScheme(link) != "" {
+			continue
+		}
+		// stylesheets imported with @import url() are visited too
+		links = append(links, ParsedLink{Source: "css", URL: r.Request.AbsoluteURL(link), Visit: strings.EqualFold(path.Ext(link), ".css")})
+	}
+	return links
+}
+
+// jsonParser extracts the URLs and paths of JSON responses, e.g. of API endpoints. Web app
+// and asset manifests are handled on their own.
+type jsonParser struct{}
+
+func (jsonParser) Handles(r *colly.Response) bool {
+	if _, ok := manifests.Load(r.Request.URL.String()); ok || path.Base(r.Request.URL.Path) == "asset-manifest.json" {
+		return false
+	}
+	t := mediaType(r)
+	return t == "application/json" || t == "text/json" || strings.HasSuffix(t, "+json")
+}
+
+func (jsonParser) Parse(r *colly.Response) []ParsedLink {
+	var document interface{}
+	if json.Unmarshal(r.Body, &document) != nil {
+		return nil
+	}
+	var links []ParsedLink
+	for _, link := range jsonURLs(document) {
+		links = append(links, ParsedLink{Source: "json", URL: r.Request.AbsoluteURL(link), Visit: true})
+	}
+	return links
+}
+
+// xmlParser extracts the URLs and paths of XML responses other than XHTML, e.g. of SOAP
+// services, feeds and sitemaps
+type xmlParser struct{}
+
+func (xmlParser) Handles(r *colly.Response) bool {
+	t := mediaType(r)
+	return t != "application/xhtml+xml" && (t == "application/xml" || t == "text/xml" || strings.HasSuffix(t, "+xml"))
+}
+
+func (xmlParser) Parse(r *colly.Response) []ParsedLink {
+	var links []ParsedLink
+	for _, link := range xmlURLs(r.Body) {
+		links = append(links, ParsedLink{Source: "xml", URL: r.Request.AbsoluteURL(link), Visit: true})
+	}
+	return links
+}
+
+// jsonURLs returns the string values of a JSON document, however nested, that look like
+// absolute URLs or paths
+func jsonURLs(document interface{}) []string {
+	var urls []string
+	switch v := document.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			urls = append(urls, jsonURLs(v[key])...)
+		}
+	case []interface{}:
+		for _, value := range v {
+			urls = append(urls, jsonURLs(value)...)
+		}
+	case string:
+		if urlValuePattern.MatchString(v) {
+			urls = append(urls, v)
+		}
+	}
+	return urls
+}
+
+// urlValuePattern matches values that are absolute or protocol-relative URLs, or paths of at
+// least one segment
+var urlValuePattern = regexp.MustCompile(`^(?:https?://[^\s/?#]+|//[^\s/?#]+|/[^\s/?#])[^\s]*$`)
+
+// xmlURLs returns the attribute values and texts of an XML document that look like absolute
+// URLs or paths. A malformed document yields the values found up to the error.
+func xmlURLs(document []byte) []string {
+	var urls []string
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	decoder.Strict = false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return urls
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			for _, attr := range t.Attr {
+				// namespace names are URLs, but not of anything to fetch
+				if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+					continue
+				}
+				if value := strings.TrimSpace(attr.Value); urlValuePattern.MatchString(value) {
+					urls = append(urls, value)
+				}
+			}
+		case xml.CharData:
+			if value := strings.TrimSpace(string(t)); urlValuePattern.MatchString(value) {
+				urls = append(urls, value)
+			}
+		}
+	}
+}
+
+// pdfParser extracts the link annotations of PDF documents, from their objects and from their
+// Flate compressed streams, where PDF 1.5 object streams keep them
+type pdfParser struct{}
+
+var (
+	// pdfStream matches the data of a stream object
+	pdfStream = regexp.MustCompile(`(?s)stream\r?\n(.*?)endstream`)
+	// pdfURI matches the URI of a link action, e.g. /URI (https://example.com/)
+	pdfURI = regexp.MustCompile(`/URI\s*\(((?:[^()\\]|\\.)*)\)`)
+)
+
+func (pdfParser) Handles(r *colly.Response) bool {
+	return mediaType(r) == "application/pdf" || strings.EqualFold(path.Ext(r.Request.URL.Path), ".pdf")
+}
+
+func (pdfParser) Parse(r *colly.Response) []ParsedLink {
+	sections := [][]byte{r.Body}
+	for _, m := range pdfStream.FindAllSubmatch(r.Body, -1) {
+		reader, err := zlib.NewReader(bytes.NewReader(m[1]))
+		if err != nil {
+			continue
+		}
+		// streams are often followed by an end of line, so what was inflated before an error is kept
+		inflated, _ := io.ReadAll(io.LimitReader(reader, 10*1024*1024))
+		sections = append(sections, inflated)
+	}
+	var links []ParsedLink
+	for _, section := range sections {
+		for _, m := range pdfURI.FindAllSubmatch(section, -1) {
+			link := pdfUnescape(string(m[1]))
+			links = append(links, ParsedLink{Source: "pdf", URL: r.Request.AbsoluteURL(link), Visit: true})
+		}
+	}
+	return links
+}
+
+// pdfUnescape undoes the backslash escapes of a PDF literal string
+func pdfUnescape(s string) string {
+	return strings.NewReplacer(`\(`, "(", `\)`, ")", `\\`, `\`).Replace(s)
+}
+
+// headerLink is a link of a Link response header, with its relation types
+type headerLink struct {
+	URL  string
+	Rels []string
+}
+
+// linkHeaderLinks parses the links of Link response headers (RFC 8288), e.g.
+// </app.js>; rel=preload; as=script, <https://hub.example.com/>; rel="hub"
+func linkHeaderLinks(values []string) []headerLink {
+	var links []headerLink
+	for _, value := range values {
+		for {
+			start := strings.Index(value, "<")
+			if start < 0 {
+				break
+			}
+			end := strings.Index(value[start:], ">")
+			if end < 0 {
+				break
+			}
+			link := headerLink{URL: strings.TrimSpace(value[start+1 : start+end])}
+			value = value[start+end+1:]
+			// the parameters run up to the next comma outside of a quoted string
+			params, rest := value, ""
+			quoted := false
+			for i, c := range value {
+				if c == '"' {
+					quoted = !quoted
+				} else if c == ',' && !quoted {
+					params, rest = value[:i], value[i+1:]
+					break
+				}
+			}
+			value = rest
+			for _, param := range strings.Split(params, ";") {
+				parts := strings.SplitN(param, "=", 2)
+				if len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), "rel") {
+					link.Rels = strings.Fields(strings.ToLower(strings.Trim(strings.TrimSpace(parts[1]), `"`)))
+				}
+			}
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// cspURLDirectives are the directives other than fetch directives (*-src) that take sources
+var cspURLDirectives = map[string]bool{"base-uri": true, "form-action": true, "frame-ancestors": true, "navigate-to": true}
+
+// cspSources lists the hosts and URLs of Content-Security-Policy headers, e.g.
+// script-src 'self' https://cdn.example.com *.example.net; report-uri /csp-report
+// Keywords, nonces, hashes and scheme sources are skipped, wildcard subdomains are replaced by
+// their parent domain, and hosts without a scheme are given scheme. report-uri endpoints are
+// returned as they are, to be resolved against the page.
+func cspSources(values []string, scheme string) []string {
+	var sources []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ";") {
+			fields := strings.Fields(directive)
+			if len(fields) < 2 {
+				continue
+			}
+			name := strings.ToLower(fields[0])
+			if name != "report-uri" && !strings.HasSuffix(name, "-src") && !cspURLDirectives[name] {
+				continue
+			}
+			for _, source := range fields[1:] {
+				switch {
+				case name == "report-uri":
+				case strings.HasPrefix(source, "'") || strings.HasSuffix(source, ":") || source == "*":
+					continue
+				default:
+					if !strings.Contains(source, "://") {
+						source = scheme + "://" + source
+					}
+					u, err := url.Parse(strings.Replace(strings.Replace(source, "://*.", "://", 1), ":*", "", 1))
+					if err != nil || u.Host == "" || strings.Contains(u.Host, "*") {
+						continue
+					}
+					source = u.String()
+				}
+				if !seen[source] {
+					seen[source] = true
+					sources = append(sources, source)
+				}
+			}
+		}
+	}
+	return sources
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gocolly/colly/v2"
+)
+
+func TestEnableParsers(t *testing.T) {
+	defer func() { activeParsers = make(map[string]Parser) }()
+
+	if err := enableParsers(" css,,json "); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := activeParsers["css"]; !ok {
+		t.Error("css parser not enabled")
+	}
+	if _, ok := activeParsers["json"]; !ok {
+		t.Error("json parser not enabled")
+	}
+	if _, ok := activeParsers["xml"]; ok {
+		t.Error("xml parser enabled")
+	}
+	err := enableParsers("css,nope")
+	if err == nil || !strings.Contains(err.Error(), `unknown parser "nope"`) {
+		t.Errorf("enableParsers(css,nope) = %v, want an unknown parser error", err)
+	}
+}
+
+func TestParserWants(t *testing.T) {
+	defer func() { activeParsers = make(map[string]Parser) }()
+
+	u, _ := url.Parse("https://example.com/report.pdf")
+	pdf := &colly.Response{
+		Request: &colly.Request{URL: u},
+		Headers: &http.Header{"Content-Type": []string{"application/pdf"}},
+	}
+	if err := enableParsers(defaultParsers); err != nil {
+		t.Fatal(err)
+	}
+	if parserWants(pdf) {
+		t.Error("the default parsers download PDFs")
+	}
+	if err := enableParsers("pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if !parserWants(pdf) {
+		t.Error("-parsers pdf does not download PDFs")
+	}
+}
+
+func TestJSONURLs(t *testing.T) {
+	const document = `{
+		"links": {"self": "/api/users?page=2", "next": "https://api.example.com/users?page=3"},
+		"items": [{"avatar": "//cdn.example.com/a.png", "name": "Alice"}, {"bio": "see /about me"}],
+		"count": 2,
+		"root": "/",
+		"callback": "https://"
+	}`
+	var v interface{}
+	if err := json.Unmarshal([]byte(document), &v); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"//cdn.example.com/a.png", "https://api.example.com/users?page=3", "/api/users?page=2"}
+	if got := jsonURLs(v); !reflect.DeepEqual(got, want) {
+		t.Errorf("jsonURLs = %q, want %q", got, want)
+	}
+}
+
+func TestXMLURLs(t *testing.T) {
+	const document = `<?xml version="1.0"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:xhtml="http://www.w3.org/1999/xhtml">
+  <url>
+    <loc> https://example.com/page </loc>
+    <xhtml:link rel="alternate" hreflang="de" href="/de/page"/>
+    <priority>0.5</priority>
+  </url>
+  <service endpoint="/soap/v1" name="Orders">/rest/orders
+  <broken`
+	want := []string{"https://example.com/page", "/de/page", "/soap/v1", "/rest/orders"}
+	if got := xmlURLs([]byte(document)); !reflect.DeepEqual(got, want) {
+		t.Errorf("xmlURLs = %q, want %q", got, want)
+	}
+}
+
+func TestLinkHeaderLinks(t *testing.T) {
+	values := []string{
+		`</app.js>; rel=preload; as=script, <https://hub.example.com/>; rel="hub"`,
+		`<https://example.com/page?p=2>; rel="next prev"; title="a, b", <style.css>`,
+		`no links here`,
+	}
+	want := []headerLink{
+		{URL: "/app.js", Rels: []string{"preload"}},
+		{URL: "https://hub.example.com/", Rels: []string{"hub"}},
+		{URL: "https://example.com/page?p=2", Rels: []string{"next", "prev"}},
+		{URL: "style.css"},
+	}
+	if got := linkHeaderLinks(values); !reflect.DeepEqual(got, want) {
+		t.Errorf("linkHeaderLinks = %+v, want %+v", got, want)
+	}
+}
+
+func TestCSPSources(t *testing.T) {
+	values := []string{
+		"default-src 'self'; script-src 'nonce-abc' https://cdn.example.com *.example.net data: blob: *; report-uri /csp-report",
+		"frame-ancestors app.example.com:*; form-action https://login.example.com/submit; sandbox allow-forms; upgrade-insecure-requests",
+		"img-src https://cdn.example.com",
+	}
+	want := []string{
+		"https://cdn.example.com",
+		"https://example.net",
+		"/csp-report",
+		"https://app.example.com",
+		"https://login.example.com/submit",
+	}
+	if got := cspSources(values, "https"); !reflect.DeepEqual(got, want) {
+		t.Errorf("cspSources = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// paused is 1 while new requests are held back, toggled with signals where available
+var paused int32
+
+// setPaused pauses or resumes the crawl. Requests already sent complete either way.
+func setPaused(pause bool) {
+	if pause && atomic.CompareAndSwapInt32(&paused, 0, 1) {
+		log.Println("[pause] holding back new requests")
+	} else if !pause && atomic.CompareAndSwapInt32(&paused, 1, 0) {
+		log.Println("[pause] resuming")
+	}
+}
+
+// waitWhilePaused blocks a request while the crawl is paused
+func waitWhilePaused(r *colly.Request) {
+	for atomic.LoadInt32(&paused) == 1 {
+		time.Sleep(200 * time.Millisecond)
+	}
+}
@@ -0,0 +1,4 @@
+package main
+
+// handlePauseSignals does nothing, as Windows has no SIGUSR1 and SIGUSR2
+func handlePauseSignals() {}
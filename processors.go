@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Processor is custom crawl logic compiled into hakrawler. To add one, put a file in this
+// package that registers it from an init function, and enable it with -processors:
+//
+//	func init() {
+//		registerProcessor("myprocessor", &myProcessor{})
+//	}
+type Processor interface {
+	// OnRequest is called before every request, and can change it or abort it with abortRequest
+	OnRequest(r *colly.Request)
+	// OnResponse is called for every response, and returns extra results to output
+	OnResponse(r *colly.Response) []Result
+	// OnResult is called for every result before it is output, and can change it or return
+	// false to drop it
+	OnResult(res *Result) bool
+}
+
+// Processors compiled in, by name
+var processors = make(map[string]Processor)
+
+// Processors enabled with -processors, in the order given
+var activeProcessors []Processor
+
+// registerProcessor makes a processor available to -processors under name
+func registerProcessor(name string, p Processor) {
+	if _, ok := processors[name]; ok {
+		panic("processor registered twice: " + name)
+	}
+	processors[name] = p
+}
+
+// enableProcessors enables the comma-separated processors in activeProcessors
+func enableProcessors(names string) error {
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		p, ok := processors[name]
+		if !ok {
+			available := make([]string, 0, len(processors))
+			for known := range processors {
+				available = append(available, known)
+			}
+			sort.Strings(available)
+			return fmt.Errorf("unknown processor %q, available: %s", name, strings.Join(available, ", "))
+		}
+		activeProcessors = append(activeProcessors, p)
+	}
+	return nil
+}
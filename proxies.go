@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// proxyRoutes picks the proxy of each request from host patterns, for -proxy-file
+type proxyRoutes struct {
+	patterns []string
+	proxies  map[string]*url.URL
+	fallback *url.URL
+	// connectHeaders are sent on CONNECT requests to every proxy, and proxyHeaders to the
+	// proxies of a host:port
+	connectHeaders http.Header
+	proxyHeaders   map[string]http.Header
+	// proxyTLS holds the TLS settings of the HTTPS proxies that have their own, by host:port.
+	// The transport is told these are HTTP proxies, and the TLS connection made when dialing.
+	proxyTLS map[string]*tls.Config
+}
+
+// proxySettings is a proxy given as an object in the proxy file, with the headers to send on
+// its CONNECT requests and, for HTTPS proxies, how to check its certificate
+type proxySettings struct {
+	URL      string            `json:"url"`
+	Headers  map[string]string `json:"headers"`
+	Insecure bool              `json:"insecure"`
+	CA       string            `json:"ca"`
+}
+
+// loadProxyRoutes reads a JSON file mapping host patterns to proxy URLs, to "direct" for no
+// proxy, or to proxy settings objects. Hosts matching no pattern go through fallback, which
+// may be nil.
+func loadProxyRoutes(filename string, fallback *url.URL) (*proxyRoutes, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	routes := &proxyRoutes{
+		proxies:      make(map[string]*url.URL),
+		fallback:     fallback,
+		proxyHeaders: make(map[string]http.Header),
+		proxyTLS:     make(map[string]*tls.Config),
+	}
+	for pattern, value := range raw {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad host pattern %q: %v", pattern, err)
+		}
+		routes.patterns = append(routes.patterns, pattern)
+		var settings proxySettings
+		if json.Unmarshal(value, &settings.URL) != nil {
+			if err := json.Unmarshal(value, &settings); err != nil {
+				return nil, fmt.Errorf("bad proxy for %q: %v", pattern, err)
+			}
+		}
+		if settings.URL == "direct" {
+			routes.proxies[pattern] = nil
+			continue
+		}
+		u, err := routes.add(settings)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy %q for %q: %v", settings.URL, pattern, err)
+		}
+		routes.proxies[pattern] = u
+	}
+	// like headersForHost, patterns are applied in sorted order and the last match wins
+	sort.Strings(routes.patterns)
+	return routes, nil
+}
+
+// add parses the settings of a proxy and returns the URL the transport is to be given for it
+func (p *proxyRoutes) add(settings proxySettings) (*url.URL, error) {
+	u, err := url.Parse(settings.URL)
+	if err != nil || u.Host == "" {
+		return nil, errors.New("not a proxy URL")
+	}
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "https":
+			u.Host += ":443"
+		case "socks5":
+			u.Host += ":1080"
+		default:
+			u.Host += ":80"
+		}
+	}
+	if settings.Headers != nil {
+		header := make(http.Header)
+		for name, value := range settings.Headers {
+			header.Set(name, value)
+		}
+		p.proxyHeaders[u.Host] = header
+	}
+	if u.Scheme != "https" || (!settings.Insecure && settings.CA == "") {
+		return u, nil
+	}
+
+	config := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: settings.Insecure}
+	if settings.CA != "" {
+		pem, err := os.ReadFile(settings.CA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", settings.CA)
+		}
+	}
+	p.proxyTLS[u.Host] = config
+	plain := *u
+	plain.Scheme = "http"
+	return &plain, nil
+}
+
+// proxy is an http.Transport Proxy function routing requests by host
+func (p *proxyRoutes) proxy(req *http.Request) (*url.URL, error) {
+	proxy := p.fallback
+	for _, pattern := range p.patterns {
+		if ok, _ := path.Match(pattern, req.URL.Hostname()); ok {
+			proxy = p.proxies[pattern]
+		}
+	}
+	return proxy, nil
+}
+
+// connectHeader is an http.Transport GetProxyConnectHeader function returning the headers of
+// the proxy a CONNECT request is sent to
+func (p *proxyRoutes) connectHeader(ctx context.Context, proxyURL *url.URL, target string) (http.Header, error) {
+	header := p.connectHeaders.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	for name, values := range p.proxyHeaders[proxyURL.Host] {
+		header[name] = values
+	}
+	return header, nil
+}
+
+// dialContext returns a dial function making the TLS connection to the HTTPS proxies that
+// have their own TLS settings, over connections made by dial, or by defaultDialer if nil
+func (p *proxyRoutes) dialContext(dial func(ctx context.Context, network string, address string) (net.Conn, error)) func(ctx context.Context, network string, address string) (net.Conn, error) {
+	if dial == nil {
+		dial = defaultDialer.DialContext
+	}
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		config, ok := p.proxyTLS[address]
+		if err != nil || !ok {
+			return conn, err
+		}
+		tlsConn := tls.Client(conn, config)
+		if deadline, ok := ctx.Deadline(); ok {
+			tlsConn.SetDeadline(deadline)
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		return tlsConn, nil
+	}
+}
+
+// parseProxyHeaders parses the -proxy-header setting, headers separated by two semi-colons like -h
+func parseProxyHeaders(raw string) (http.Header, error) {
+	header := make(http.Header)
+	for _, line := range strings.Split(raw, ";;") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("expected \"Name: value\", got %q", line)
+		}
+		header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return header, nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// crawlReport collects the results output during the crawl, for -report
+type crawlReport struct {
+	mu      sync.Mutex
+	results []Result
+}
+
+// Results collected for reports, or nil
+var report *crawlReport
+
+func (r *crawlReport) add(res Result) {
+	r.mu.Lock()
+	r.results = append(r.results, res)
+	r.mu.Unlock()
+}
+
+// hostStats counts the results of a hostname
+type hostStats struct {
+	Host    string
+	Results int
+	Sources map[string]int
+}
+
+// siteNode is a path segment of the site tree, with the segments below it
+type siteNode struct {
+	Name     string
+	URL      string
+	Children []*siteNode
+}
+
+// reportData is what the report templates are given
+type reportData struct {
+	Results  []Result
+	Hosts    []hostStats
+	Statuses []statusCount
+	Tree     []*siteNode
+}
+
+type statusCount struct {
+	Status int
+	Count  int
+}
+
+// summarize computes the per-host stats, status code breakdown and site tree of the results
+func (r *crawlReport) summarize() reportData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := reportData{Results: r.results}
+	hosts := make(map[string]*hostStats)
+	statuses := make(map[int]int)
+	roots := make(map[string]*siteNode)
+	nodes := make(map[string]*siteNode)
+	for _, res := range r.results {
+		u, err := url.Parse(res.URL)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		stats, ok := hosts[u.Hostname()]
+		if !ok {
+			stats = &hostStats{Host: u.Hostname(), Sources: make(map[string]int)}
+			hosts[u.Hostname()] = stats
+		}
+		stats.Results++
+		stats.Sources[res.Source]++
+		if res.Status != 0 {
+			statuses[res.Status]++
+		}
+
+		origin := u.Scheme + "://" + u.Host
+		parent, ok := roots[origin]
+		if !ok {
+			parent = &siteNode{Name: origin, URL: origin + "/"}
+			roots[origin] = parent
+		}
+		prefix := origin
+		for _, segment := range strings.Split(strings.Trim(u.Path, "/"), "/") {
+			if segment == "" {
+				continue
+			}
+			prefix += "/" + segment
+			node, ok := nodes[prefix]
+			if !ok {
+				node = &siteNode{Name: segment, URL: prefix}
+				nodes[prefix] = node
+				parent.Children = append(parent.Children, node)
+			}
+			parent = node
+		}
+	}
+
+	for _, stats := range hosts {
+		data.Hosts = append(data.Hosts, *stats)
+	}
+	sort.Slice(data.Hosts, func(i, j int) bool { return data.Hosts[i].Host < data.Hosts[j].Host })
+	for status, count := range statuses {
+		data.Statuses = append(data.Statuses, statusCount{status, count})
+	}
+	sort.Slice(data.Statuses, func(i, j int) bool { return data.Statuses[i].Status < data.Statuses[j].Status })
+	for _, root := range roots {
+		sortTree(root)
+		data.Tree = append(data.Tree, root)
+	}
+	sort.Slice(data.Tree, func(i, j int) bool { return data.Tree[i].Name < data.Tree[j].Name })
+	return data
+}
+
+func sortTree(node *siteNode) {
+	sort.Slice(node.Children, func(i, j int) bool { return node.Children[i].Name < node.Children[j].Name })
+	for _, child := range node.Children {
+		sortTree(child)
+	}
+}
+
+// writeHTML writes a self-contained HTML report to filename
+func (r *crawlReport) writeHTML(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return htmlReport.Execute(file, r.summarize())
+}
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>hakrawler report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; font-size: 0.9em; }
+details details { margin-left: 1.2em; }
+summary, .leaf { font-family: monospace; }
+.leaf { margin-left: 2.4em; display: block; }
+#search { width: 30em; margin-bottom: 0.5em; }
+</style>
+</head>
+<body>
+<h1>hakrawler report</h1>
+<p>{{len .Results}} results from {{len .Hosts}} hosts.</p>
+
+<h2>Hosts</h2>
+<table>
+<tr><th>Host</th><th>Results</th><th>Sources</th></tr>
+{{range .Hosts}}<tr><td>{{.Host}}</td><td>{{.Results}}</td><td>{{range $source, $count := .Sources}}{{$source}}: {{$count}} {{end}}</td></tr>
+{{end}}</table>
+
+{{if .Statuses}}<h2>Status codes</h2>
+<table>
+<tr><th>Status</th><th>Results</th></tr>
+{{range .Statuses}}<tr><td>{{.Status}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Site tree</h2>
+{{define "node"}}{{if .Children}}<details><summary>{{.Name}}</summary>{{range .Children}}{{template "node" .}}{{end}}</details>{{else}}<a class="leaf" href="{{.URL}}">{{.Name}}</a>{{end}}{{end}}
+{{range .Tree}}{{template "node" .}}{{end}}
+
+<h2>Results</h2>
+<input id="search" type="search" placeholder="Filter results" oninput="filter(this.value)">
+<table id="results">
+<tr><th>Source</th><th>URL</th><th>Status</th><th>Content-Type</th></tr>
+{{range .Results}}<tr><td>{{.Source}}</td><td>{{if .Match}}{{.Match}} {{end}}<a href="{{.URL}}">{{.URL}}</a></td><td>{{if .Status}}{{.Status}}{{end}}</td><td>{{.ContentType}}</td></tr>
+{{end}}</table>
+<script>
+function filter(text) {
+	text = text.toLowerCase();
+	var rows = document.getElementById("results").rows;
+	for (var i = 1; i < rows.length; i++) {
+		rows[i].style.display = rows[i].textContent.toLowerCase().indexOf(text) >= 0 ? "" : "none";
+	}
+}
+</script>
+</body>
+</html>
+`))
+
+// interestingSources are the sources listed as findings in the Markdown report
+var interestingSources = map[string]bool{
+	"match": true, "regex": true, "reflection": true, "upload": true, "xhr": true, "click-xhr": true, "endpoint": true,
+}
+
+// loadBaseline reads the URLs of an earlier run's output, in plain or JSON lines format
+func loadBaseline(filename string) (map[string]bool, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	urls := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var res Result
+		if strings.HasPrefix(line, "{") && json.Unmarshal([]byte(line), &res) == nil {
+			line = res.URL
+		}
+		if line != "" {
+			urls[line] = true
+		}
+	}
+	return urls, scanner.Err()
+}
+
+// writeMarkdown writes a Markdown summary of the hosts, findings and, given a baseline, of the
+// URLs not found in it to filename
+func (r *crawlReport) writeMarkdown(filename string, baseline map[string]bool) error {
+	data := r.summarize()
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Crawl summary\n\n%d results from %d hosts.\n\n", len(data.Results), len(data.Hosts))
+
+	b.WriteString("## Hosts\n\n| Host | Results |\n| --- | --- |\n")
+	for _, host := range data.Hosts {
+		fmt.Fprintf(&b, "| %s | %d |\n", markdownEscape(host.Host), host.Results)
+	}
+
+	var findings []string
+	for _, res := range data.Results {
+		switch {
+		case interestingSources[res.Source] && res.Match != "":
+			findings = append(findings, fmt.Sprintf("- **%s** `%s` in %s", res.Source, strings.ReplaceAll(res.Match, "`", "'"), markdownEscape(res.URL)))
+		case interestingSources[res.Source] && res.Parameter != "":
+			findings = append(findings, fmt.Sprintf("- **%s** parameter `%s` (%s) in %s", res.Source, res.Parameter, strings.Join(res.Contexts, ", "), markdownEscape(res.URL)))
+		case interestingSources[res.Source]:
+			findings = append(findings, fmt.Sprintf("- **%s** %s", res.Source, markdownEscape(res.URL)))
+		case res.Category == "cors-misconfiguration":
+			findings = append(findings, fmt.Sprintf("- **cors** origin `%s` allowed with credentials in %s", res.AllowOrigin, markdownEscape(res.URL)))
+		case res.RedirectParams != nil:
+			findings = append(findings, fmt.Sprintf("- **redirect** parameters `%s` in %s", strings.Join(res.RedirectParams, "`, `"), markdownEscape(res.URL)))
+		}
+	}
+	if findings != nil {
+		b.WriteString("\n## Findings\n\n" + strings.Join(dedupe(findings), "\n") + "\n")
+	}
+
+	if baseline != nil {
+		var added []string
+		for _, res := range data.Results {
+			if !baseline[res.URL] {
+				added = append(added, "- "+markdownEscape(res.URL))
+			}
+		}
+		added = dedupe(added)
+		fmt.Fprintf(&b, "\n## New since baseline\n\n%d URLs not in the baseline.\n\n", len(added))
+		if added != nil {
+			b.WriteString(strings.Join(added, "\n") + "\n")
+		}
+	}
+	return os.WriteFile(filename, []byte(b.String()), 0644)
+}
+
+// dedupe removes repeated lines, keeping the first of each
+func dedupe(lines []string) []string {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		if !seen[line] {
+			seen[line] = true
+			unique = append(unique, line)
+		}
+	}
+	return unique
+}
+
+// markdownEscape escapes the characters of a URL that Markdown would interpret
+func markdownEscape(s string) string {
+	return strings.NewReplacer("|", "\\|", "*", "\\*", "_", "\\_", "<", "&lt;", "[", "\\[", "]", "\\]").Replace(s)
+}
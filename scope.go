@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// variableSegment matches path segments that look like identifiers rather than fixed names:
+// numbers, UUIDs, hashes, and slugs containing digits
+var variableSegment = regexp.MustCompile(`^(\d+|[0-9a-fA-F-]{32,36}|[0-9a-fA-F]{8,}|.*\d.*)$`)
+
+// pathTemplate reduces a URL to the template it was likely generated from, so that e.g.
+// /product/12 and /product/34?color=red both become example.com/product/{id}?color
+func pathTemplate(u *url.URL) string {
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i, segment := range segments {
+		if segment != "" && variableSegment.MatchString(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	template := u.Host + strings.Join(segments, "/")
+	if u.RawQuery != "" {
+		keys := make([]string, 0)
+		for key := range u.Query() {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		template += "?" + strings.Join(keys, "&")
+	}
+	return template
+}
+
+// patternBudget limits how many URLs sharing the same path template get visited
+type patternBudget struct {
+	limit  int
+	mu     sync.Mutex
+	visits map[string]int
+}
+
+// check aborts a request once its path template has used up its budget
+func (b *patternBudget) check(r *colly.Request) {
+	template := pathTemplate(r.URL)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.visits == nil {
+		b.visits = make(map[string]int)
+	}
+	if b.visits[template] >= b.limit {
+		abortRequest(r)
+		return
+	}
+	b.visits[template]++
+}
+
+var (
+	// sessionInPath matches session IDs embedded in paths, which make every visit look like a new URL
+	sessionInPath = regexp.MustCompile(`(?i);jsessionid=|/\(S\([a-z0-9]+\)\)|[;/](phpsessid|sid|sessionid)=`)
+	// datePattern matches a year followed by a month, e.g. 2031-04 or 2031/4
+	datePattern = regexp.MustCompile(`\b(1[89]\d\d|2\d\d\d)[-/.](0?[1-9]|1[0-2])\b`)
+	// dateParameter matches the names of query parameters that usually hold dates
+	dateParameter = regexp.MustCompile(`(?i)^(year|yr|month|date|day|cal\w*)$`)
+	yearPattern   = regexp.MustCompile(`^(1[89]\d\d|2\d\d\d)\b`)
+)
+
+// maxPathSegments is the path depth beyond which a URL is considered a trap
+const maxPathSegments = 20
+
+// crawlerTrap reports why a URL looks like a crawler trap, or returns "" if it does not
+func crawlerTrap(u *url.URL, now time.Time) string {
+	if sessionInPath.MatchString(u.EscapedPath()) {
+		return "session id in path"
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) > maxPathSegments {
+		return "path too deep"
+	}
+	// the same sequence of segments repeated three times in a row, e.g. /a/a/a or /a/b/a/b/a/b
+	for size := 1; size*3 <= len(segments); size++ {
+		for start := 0; start+size*3 <= len(segments); start++ {
+			a := strings.Join(segments[start:start+size], "/")
+			b := strings.Join(segments[start+size:start+size*2], "/")
+			c := strings.Join(segments[start+size*2:start+size*3], "/")
+			if a == b && b == c {
+				return "repeating path segments"
+			}
+		}
+	}
+
+	// calendars happily link to next month forever, so give up on dates years into the future.
+	// Past dates are left alone, as archives run back for decades.
+	var years []string
+	for _, m := range datePattern.FindAllStringSubmatch(u.Path+"?"+u.RawQuery, -1) {
+		years = append(years, m[1])
+	}
+	for key, values := range u.Query() {
+		if dateParameter.MatchString(key) {
+			for _, value := range values {
+				if m := yearPattern.FindString(value); m != "" {
+					years = append(years, m)
+				}
+			}
+		}
+	}
+	for _, y := range years {
+		year, _ := strconv.Atoi(y)
+		if year > now.Year()+2 {
+			return "calendar date out of range"
+		}
+	}
+	return ""
+}
+
+// subdomainFilter returns a regex matching the URLs of the subdomains of hostname starting
+// with one of the comma-separated prefixes, e.g. cdn. or static., or nil if there are none
+func subdomainFilter(prefixes string, hostname string) *regexp.Regexp {
+	var quoted []string
+	for _, prefix := range strings.Split(prefixes, ",") {
+		if prefix = strings.ToLower(strings.TrimSpace(prefix)); prefix != "" {
+			quoted = append(quoted, regexp.QuoteMeta(prefix))
+		}
+	}
+	if quoted == nil {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)^[a-z][a-z0-9+.-]*://(?:[^/?#@]*@)?(?:` + strings.Join(quoted, "|") + `)(?:[^/?#@]*\.)?` +
+		regexp.QuoteMeta(hostname) + `(?:[:/?#]|$)`)
+}
+
+// loadScopeFile reads scope rules, one per line: "include" or "exclude" followed by a pattern.
+// Patterns are either regexes of whole URLs prefixed with "re:", or Burp-style wildcards of an
+// optional scheme, a host and an optional path prefix, e.g.
+//
+//	include *.example.com
+//	include admin.example.com:8443
+//	include https://app.example.com/api/
+//	exclude cdn.example.com
+//	exclude re:(?i)/logout
+func loadScopeFile(filename string) (include []*regexp.Regexp, exclude []*regexp.Regexp, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("line %d: expected \"include <pattern>\" or \"exclude <pattern>\"", line)
+		}
+		re, err := scopePattern(fields[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		switch strings.ToLower(fields[0]) {
+		case "include":
+			include = append(include, re)
+		case "exclude":
+			exclude = append(exclude, re)
+		default:
+			return nil, nil, fmt.Errorf("line %d: unknown rule %q", line, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if include == nil {
+		return nil, nil, errors.New("no include rules")
+	}
+	return include, exclude, nil
+}
+
+// scopePattern turns a scope file pattern into a regex matching the URLs it covers
+func scopePattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, "re:") {
+		return regexp.Compile(pattern[len("re:"):])
+	}
+
+	scheme := `https?`
+	if i := strings.Index(pattern, "://"); i >= 0 {
+		scheme = wildcard(pattern[:i], `[a-z]*`)
+		pattern = pattern[i+len("://"):]
+	}
+	host, pathPrefix := pattern, ""
+	if i := strings.Index(pattern, "/"); i >= 0 {
+		host, pathPrefix = pattern[:i], pattern[i:]
+	}
+	if host == "" {
+		return nil, fmt.Errorf("no host in %q", pattern)
+	}
+
+	re := `(?i)^` + scheme + `://(?:[^/?#@]*@)?` + wildcard(host, `[^/?#@:]*`)
+	if !strings.Contains(host, ":") {
+		re += `(?::\d+)?`
+	}
+	if pathPrefix != "" {
+		re += wildcard(pathPrefix, `.*`)
+	} else {
+		re += `(?:[/?#]|$)`
+	}
+	return regexp.Compile(re)
+}
+
+// wildcard quotes s for a regex, with its * wildcards matching any
+func wildcard(s string, any string) string {
+	return strings.ReplaceAll(regexp.QuoteMeta(s), `\*`, any)
+}
+
+// burpScopeRule is an include or exclude rule of a Burp Suite target scope export. Simple rules
+// have a URL prefix, advanced ones a protocol and regexes of the host, port and file.
+type burpScopeRule struct {
+	Enabled  bool   `json:"enabled"`
+	Prefix   string `json:"prefix"`
+	Protocol string `json:"protocol"`
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	File     string `json:"file"`
+}
+
+// loadBurpScope reads the target scope exported by Burp Suite (Project options, "Save options"
+// or Target > Scope) and translates its enabled rules into regexes of the URLs they cover
+func loadBurpScope(filename string) (include []*regexp.Regexp, exclude []*regexp.Regexp, err error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	var options struct {
+		Target struct {
+			Scope struct {
+				Include []burpScopeRule `json:"include"`
+				Exclude []burpScopeRule `json:"exclude"`
+			} `json:"scope"`
+		} `json:"target"`
+	}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return nil, nil, err
+	}
+	translate := func(rules []burpScopeRule) ([]*regexp.Regexp, error) {
+		var res []*regexp.Regexp
+		for i, rule := range rules {
+			if !rule.Enabled {
+				continue
+			}
+			re, err := rule.regexp()
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %v", i+1, err)
+			}
+			res = append(res, re)
+		}
+		return res, nil
+	}
+	if include, err = translate(options.Target.Scope.Include); err != nil {
+		return nil, nil, fmt.Errorf("include %v", err)
+	}
+	if exclude, err = translate(options.Target.Scope.Exclude); err != nil {
+		return nil, nil, fmt.Errorf("exclude %v", err)
+	}
+	if include == nil {
+		return nil, nil, errors.New("no enabled include rules")
+	}
+	return include, exclude, nil
+}
+
+// regexp returns a regex matching the URLs a Burp scope rule covers. A port given by a rule
+// also matches URLs leaving out that port when it is the default one of their scheme.
+func (rule burpScopeRule) regexp() (*regexp.Regexp, error) {
+	if rule.Prefix != "" {
+		return scopePattern(rule.Prefix)
+	}
+	for _, part := range []string{rule.Host, rule.Port, rule.File} {
+		if _, err := regexp.Compile(part); err != nil {
+			return nil, err
+		}
+	}
+
+	schemes := []string{"http", "https"}
+	if rule.Protocol != "" && !strings.EqualFold(rule.Protocol, "any") {
+		schemes = []string{strings.ToLower(rule.Protocol)}
+	}
+	host, err := hostOnly(burpRegex(rule.Host, `[^/?#@:]*`))
+	if err != nil {
+		return nil, err
+	}
+	var origins []string
+	for _, scheme := range schemes {
+		port := `(?::\d+)?`
+		if rule.Port != "" {
+			port = `:` + burpRegex(rule.Port, `\d*`)
+			defaultPort := "443"
+			if scheme == "http" {
+				defaultPort = "80"
+			}
+			if regexp.MustCompile(`^` + burpRegex(rule.Port, "") + `$`).MatchString(defaultPort) {
+				port = `(?:` + port + `)?`
+			}
+		}
+		origins = append(origins, regexp.QuoteMeta(scheme)+`://(?:[^/?#@]*@)?`+host+port)
+	}
+	file := `(?:[/?#]|$)`
+	if rule.File != "" {
+		file = burpRegex(rule.File, `[^#]*`)
+	}
+	return regexp.Compile(`(?i)^(?:` + strings.Join(origins, "|") + `)` + file)
+}
+
+// burpRegex turns a regex matching a part of a URL on its own into one matching it in place:
+// its ^ and $ anchors are dropped, and where it is not anchored, what else the part may
+// contain is matched instead
+func burpRegex(pattern string, rest string) string {
+	start, end := rest, rest
+	if strings.HasPrefix(pattern, "^") {
+		pattern, start = pattern[1:], ""
+	}
+	if strings.HasSuffix(pattern, "$") && !strings.HasSuffix(pattern, `\$`) {
+		pattern, end = pattern[:len(pattern)-1], ""
+	}
+	if pattern == "" {
+		return rest
+	}
+	return start + `(?:` + pattern + `)` + end
+}
+
+// hostOnly rewrites a regex of hostnames so that its wildcards and negated classes, such as
+// the .* of .*\.example\.com, cannot match the characters separating a host from the rest
+// of a URL, which would let e.g. https://evil.test/?x.example.com into scope
+func hostOnly(pattern string) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	var walk func(re *syntax.Regexp)
+	walk = func(re *syntax.Regexp) {
+		switch re.Op {
+		case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+			re.Op, re.Rune = syntax.OpCharClass, []rune{0, unicode.MaxRune}
+			fallthrough
+		case syntax.OpCharClass:
+			var ranges []rune
+			for i := 0; i+1 < len(re.Rune); i += 2 {
+				lo, hi := re.Rune[i], re.Rune[i+1]
+				for _, sep := range "#/:?@" {
+					if sep >= lo && sep <= hi {
+						if sep > lo {
+							ranges = append(ranges, lo, sep-1)
+						}
+						lo = sep + 1
+					}
+				}
+				if lo <= hi {
+					ranges = append(ranges, lo, hi)
+				}
+			}
+			re.Rune = ranges
+		}
+		for _, sub := range re.Sub {
+			walk(sub)
+		}
+	}
+	walk(re)
+	return re.String(), nil
+}
+
+// cidrScope lets hosts resolving into given IP ranges into scope, for -scope-cidr
+type cidrScope struct {
+	nets     []*net.IPNet
+	resolver *ipResolver
+	// resolved caches whether each hostname resolves into the ranges
+	resolved sync.Map
+}
+
+// newCIDRScope parses comma-separated CIDR ranges, to check hostnames resolved with resolver.
+// Single addresses are taken as /32 or /128.
+func newCIDRScope(list string, resolver *ipResolver) (*cidrScope, error) {
+	s := &cidrScope{resolver: resolver}
+	for _, cidr := range strings.Split(list, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		s.nets = append(s.nets, ipNet)
+	}
+	return s, nil
+}
+
+// contains reports whether hostname is an address in the ranges, or resolves to one
+func (s *cidrScope) contains(hostname string) bool {
+	if in, ok := s.resolved.Load(hostname); ok {
+		return in.(bool)
+	}
+	in := false
+	for _, ip := range s.resolver.resolve(hostname) {
+		for _, ipNet := range s.nets {
+			if ipNet.Contains(ip) {
+				in = true
+			}
+		}
+	}
+	s.resolved.Store(hostname, in)
+	return in
+}
+
+// allows reports whether a URL is in the scope of a collector, given its allowed domains and
+// URL filters, or is an http or https URL on an allowed port of a host within the ranges. Hosts
+// are only resolved once the cheaper checks pass.
+func (s *cidrScope) allows(u *url.URL, allowedDomains []string, urlFilters []*regexp.Regexp, ports map[string]bool) bool {
+	if inCollectorScope(u, allowedDomains, urlFilters) {
+		return true
+	}
+	if !strings.EqualFold(u.Scheme, "http") && !strings.EqualFold(u.Scheme, "https") {
+		return false
+	}
+	if ports != nil && !ports[effectivePort(u)] {
+		return false
+	}
+	return s.contains(u.Hostname())
+}
+
+// inCollectorScope reports whether a URL passes the allowed domains and URL filters of a collector
+func inCollectorScope(u *url.URL, allowedDomains []string, urlFilters []*regexp.Regexp) bool {
+	if len(allowedDomains) > 0 {
+		allowed := false
+		for _, domain := range allowedDomains {
+			if strings.EqualFold(domain, u.Hostname()) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	if len(urlFilters) > 0 {
+		for _, re := range urlFilters {
+			if re.MatchString(u.String()) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// effectivePort returns the port of a URL, or the default port of its scheme
+func effectivePort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if strings.EqualFold(u.Scheme, "http") {
+		return "80"
+	}
+	return "443"
+}
+
+// parsePorts parses the -ports setting for a target: "any" for no restriction (nil), "same"
+// for the target's own port only, or a comma-separated list the target's port is added to
+func parsePorts(list string, target string) (map[string]bool, error) {
+	if list == "any" {
+		return nil, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	ports := map[string]bool{effectivePort(u): true}
+	if list == "same" {
+		return ports, nil
+	}
+	for _, port := range strings.Split(list, ",") {
+		port = strings.TrimSpace(port)
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("bad port %q", port)
+		}
+		ports[port] = true
+	}
+	return ports, nil
+}
+
+// upgradeToHTTPS turns an http URL into its https equivalent, dropping the default http port
+func upgradeToHTTPS(u *url.URL) {
+	if !strings.EqualFold(u.Scheme, "http") {
+		return
+	}
+	u.Scheme = "https"
+	if u.Port() == "80" {
+		u.Host = u.Hostname()
+		if strings.Contains(u.Host, ":") {
+			u.Host = "[" + u.Host + "]"
+		}
+	}
+}
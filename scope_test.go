@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCrawlerTrap(t *testing.T) {
+	now := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		url    string
+		reason string
+	}{
+		{"https://example.com/", ""},
+		{"https://example.com/blog/2026/10/post", ""},
+		{"https://example.com/app;jsessionid=0123ABCD/page", "session id in path"},
+		{"https://example.com/(S(abc123xyz))/default.aspx", "session id in path"},
+		{"https://example.com/a/b/c/d/e/f/g/h/i/j/k/l/m/n/o/p/q/r/s/t/u", "path too deep"},
+		{"https://example.com/a/a/a", "repeating path segments"},
+		{"https://example.com/x/a/b/a/b/a/b/y", "repeating path segments"},
+		{"https://example.com/a/b/a/b", ""},
+		{"https://example.com/calendar/2031-04", "calendar date out of range"},
+		{"https://example.com/calendar/2028/12", ""},
+		{"https://example.com/archive/1990/01", ""},
+		{"https://example.com/archive/2004/05", ""},
+		{"https://example.com/events?year=1999", ""},
+		{"https://example.com/events?year=2099", "calendar date out of range"},
+		{"https://example.com/events?date=2027-01-01", ""},
+		{"https://example.com/products?id=2099", ""},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reason := crawlerTrap(u, now); reason != test.reason {
+			t.Errorf("crawlerTrap(%s) = %q, want %q", test.url, reason, test.reason)
+		}
+	}
+}
+
+func TestScopePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+		match   bool
+	}{
+		{"example.com", "https://example.com/", true},
+		{"example.com", "http://example.com", true},
+		{"example.com", "https://example.com:8443/a", true},
+		{"example.com", "https://user@example.com/a", true},
+		{"example.com", "https://www.example.com/", false},
+		{"example.com", "https://example.com.evil.net/", false},
+		{"example.com", "ftp://example.com/", false},
+		{"*.example.com", "https://app.example.com/x", true},
+		{"*.example.com", "https://a.b.example.com/", true},
+		{"*.example.com", "https://example.org/?q=.example.com", false},
+		{"admin.example.com:8443", "https://admin.example.com:8443/", true},
+		{"admin.example.com:8443", "https://admin.example.com/", false},
+		{"https://app.example.com/api/", "https://app.example.com/api/users", true},
+		{"https://app.example.com/api/", "http://app.example.com/api/users", false},
+		{"https://app.example.com/api/", "https://app.example.com/static/app.js", false},
+		{"https://app.example.com/api/*/edit", "https://app.example.com/api/users/edit", true},
+		{"re:(?i)/logout", "https://example.com/LOGOUT", true},
+		{"re:(?i)/logout", "https://example.com/login", false},
+	}
+	for _, test := range tests {
+		re, err := scopePattern(test.pattern)
+		if err != nil {
+			t.Fatalf("scopePattern(%q): %v", test.pattern, err)
+		}
+		if match := re.MatchString(test.url); match != test.match {
+			t.Errorf("scopePattern(%q) matches %s = %v, want %v", test.pattern, test.url, match, test.match)
+		}
+	}
+	for _, pattern := range []string{"https:///path", "re:("} {
+		if _, err := scopePattern(pattern); err == nil {
+			t.Errorf("scopePattern(%q) succeeded, want an error", pattern)
+		}
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		list   string
+		target string
+		want   map[string]bool
+	}{
+		{"any", "https://example.com", nil},
+		{"same", "https://example.com", map[string]bool{"443": true}},
+		{"same", "http://example.com", map[string]bool{"80": true}},
+		{"same", "http://example.com:8080/app", map[string]bool{"8080": true}},
+		{"8443, 9000", "https://example.com", map[string]bool{"443": true, "8443": true, "9000": true}},
+	}
+	for _, test := range tests {
+		got, err := parsePorts(test.list, test.target)
+		if err != nil {
+			t.Errorf("parsePorts(%q, %q): %v", test.list, test.target, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parsePorts(%q, %q) = %v, want %v", test.list, test.target, got, test.want)
+		}
+	}
+	for _, list := range []string{"0", "65536", "http", "80,"} {
+		if _, err := parsePorts(list, "https://example.com"); err == nil {
+			t.Errorf("parsePorts(%q) succeeded, want an error", list)
+		}
+	}
+}
+
+func TestCIDRScopeAllows(t *testing.T) {
+	scope, err := newCIDRScope("10.0.0.0/8, 192.0.2.7", &ipResolver{hosts: hostMap{"intranet.corp": "10.1.2.3", "www.example.org": "203.0.113.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowedDomains := []string{"example.com"}
+	ports := map[string]bool{"443": true, "8443": true}
+	tests := []struct {
+		url   string
+		allow bool
+	}{
+		{"https://example.com/", true},
+		{"http://example.com:8080/", true},
+		{"https://10.20.30.40/", true},
+		{"https://192.0.2.7:8443/admin", true},
+		{"https://192.0.2.8/", false},
+		{"https://intranet.corp/", true},
+		{"https://www.example.org/", false},
+		{"http://intranet.corp/", false},
+		{"ftp://10.20.30.40/", false},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allow := scope.allows(u, allowedDomains, nil, ports); allow != test.allow {
+			t.Errorf("allows(%s) = %v, want %v", test.url, allow, test.allow)
+		}
+	}
+	// hosts on other ports are not resolved
+	if _, resolved := scope.resolved.Load("intranet.corp"); !resolved {
+		t.Error("intranet.corp not resolved")
+	}
+	u, _ := url.Parse("https://unresolved.example.net:9000/")
+	scope.allows(u, allowedDomains, nil, ports)
+	if _, resolved := scope.resolved.Load("unresolved.example.net"); resolved {
+		t.Error("host on a disallowed port resolved")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// scriptProcessor is a Processor handing requests and responses to a script run alongside the
+// crawl, so one-off logic can be written in any language. The script reads one JSON message
+// per line on stdin and answers each with one JSON line on stdout:
+//
+//	{"type":"request","method":"GET","url":"...","headers":{...}}
+//	  -> {"drop":true} to abort the request, or {"headers":{"X-Foo":"bar"}} to set headers
+//	{"type":"response","url":"...","status":200,"headers":{...},"body":"..."}
+//	  -> {"results":[{"Source":"custom","URL":"..."}],"visit":["..."]}
+//
+// An empty object leaves things as they are. Messages are sent one at a time.
+type scriptProcessor struct {
+	mu     sync.Mutex
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	cmd    *exec.Cmd
+}
+
+// scriptMessage is what is sent to the script
+type scriptMessage struct {
+	Type    string            `json:"type"`
+	Method  string            `json:"method,omitempty"`
+	URL     string            `json:"url"`
+	Status  int               `json:"status,omitempty"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// scriptReply is what the script answers
+type scriptReply struct {
+	Drop    bool              `json:"drop"`
+	Headers map[string]string `json:"headers"`
+	Results []Result          `json:"results"`
+	Visit   []string          `json:"visit"`
+}
+
+// newScriptProcessor starts the script command, split on spaces
+func newScriptProcessor(command string) (*scriptProcessor, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, errors.New("empty script command")
+	}
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &scriptProcessor{stdin: stdin, stdout: bufio.NewReader(stdout), cmd: cmd}, nil
+}
+
+// call sends a message to the script and reads its reply
+func (s *scriptProcessor) call(msg scriptMessage) (scriptReply, error) {
+	var reply scriptReply
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return reply, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.stdin.Write(append(line, '\n')); err != nil {
+		return reply, err
+	}
+	answer, err := s.stdout.ReadBytes('\n')
+	if err != nil {
+		return reply, err
+	}
+	err = json.Unmarshal(answer, &reply)
+	return reply, err
+}
+
+// close stops the script by closing its stdin and waits for it to exit
+func (s *scriptProcessor) close() {
+	s.stdin.Close()
+	s.cmd.Wait()
+}
+
+func (s *scriptProcessor) OnRequest(r *colly.Request) {
+	reply, err := s.call(scriptMessage{Type: "request", Method: r.Method, URL: r.URL.String(), Headers: flattenHeaders(*r.Headers)})
+	if err != nil {
+		log.Println("Error running script:", err)
+		return
+	}
+	if reply.Drop {
+		abortRequest(r)
+		return
+	}
+	for header, value := range reply.Headers {
+		r.Headers.Set(header, value)
+	}
+}
+
+func (s *scriptProcessor) OnResponse(r *colly.Response) []Result {
+	reply, err := s.call(scriptMessage{
+		Type:    "response",
+		URL:     r.Request.URL.String(),
+		Status:  r.StatusCode,
+		Headers: flattenHeaders(*r.Headers),
+		Body:    string(r.Body),
+	})
+	if err != nil {
+		log.Println("Error running script:", err)
+		return nil
+	}
+	for _, link := range reply.Visit {
+		queueVisit(r.Request, link)
+	}
+	for i := range reply.Results {
+		reply.Results[i].URL = r.Request.AbsoluteURL(reply.Results[i].URL)
+		if reply.Results[i].Source == "" {
+			reply.Results[i].Source = "script"
+		}
+	}
+	return reply.Results
+}
+
+func (s *scriptProcessor) OnResult(res *Result) bool {
+	return true
+}
+
+// flattenHeaders keeps the first value of every header
+func flattenHeaders(h http.Header) map[string]string {
+	flat := make(map[string]string, len(h))
+	for name := range h {
+		flat[name] = h.Get(name)
+	}
+	return flat
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// harSession is what gets taken from a HAR recording: the URLs requested, and the cookies and
+// authentication headers that were sent with them
+type harSession struct {
+	urls    []string
+	cookies []jarCookie
+	headers map[string]string
+}
+
+// parseHAR reads the requests of a HAR file, as saved by browser developer tools and proxies
+func parseHAR(filename string) (*harSession, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var har struct {
+		Log struct {
+			Entries []struct {
+				Request struct {
+					URL     string `json:"url"`
+					Headers []struct {
+						Name  string `json:"name"`
+						Value string `json:"value"`
+					} `json:"headers"`
+					Cookies []struct {
+						Name     string `json:"name"`
+						Value    string `json:"value"`
+						Path     string `json:"path"`
+						Domain   string `json:"domain"`
+						Expires  string `json:"expires"`
+						HTTPOnly bool   `json:"httpOnly"`
+						Secure   bool   `json:"secure"`
+					} `json:"cookies"`
+				} `json:"request"`
+			} `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, err
+	}
+
+	session := &harSession{headers: make(map[string]string)}
+	for _, entry := range har.Log.Entries {
+		req := entry.Request
+		session.urls = append(session.urls, req.URL)
+		for _, header := range req.Headers {
+			if isAuthHeader(header.Name) {
+				session.headers[http.CanonicalHeaderKey(header.Name)] = header.Value
+			}
+		}
+		for _, c := range req.Cookies {
+			cookie := &http.Cookie{
+				Name:     c.Name,
+				Value:    c.Value,
+				Path:     c.Path,
+				Domain:   c.Domain,
+				Secure:   c.Secure,
+				HttpOnly: c.HTTPOnly,
+			}
+			if expires, err := time.Parse(time.RFC3339, c.Expires); err == nil {
+				cookie.Expires = expires
+			}
+			session.cookies = append(session.cookies, jarCookie{url: req.URL, cookie: cookie})
+		}
+	}
+	return session, nil
+}
+
+// isAuthHeader reports whether a request header is likely to carry a session or credentials
+func isAuthHeader(name string) bool {
+	name = strings.ToLower(name)
+	if name == "authorization" {
+		return true
+	}
+	if !strings.HasPrefix(name, "x-") {
+		return false
+	}
+	for _, hint := range []string{"token", "auth", "key", "csrf", "session"} {
+		if strings.Contains(name, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBurpItems reads the URLs of a Burp "Save items" XML export. ZAP URL exports, which list
+// one URL per line, are accepted as well.
+func parseBurpItems(filename string) ([]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://") {
+				urls = append(urls, line)
+			}
+		}
+		return urls, nil
+	}
+
+	var items struct {
+		Items []struct {
+			URL string `xml:"url"`
+		} `xml:"item"`
+	}
+	if err := xml.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	for _, item := range items.Items {
+		if u := strings.TrimSpace(item.URL); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// sessionWatchdog periodically checks that the crawl is still authenticated. When the session is
+// lost, new requests are held back while it tries to log in again, and aborted if that fails.
+type sessionWatchdog struct {
+	checkURL  string
+	loggedOut *regexp.Regexp
+	interval  time.Duration
+	client    *http.Client
+	collector *colly.Collector
+	// relogin restores the session, or is nil if there is no way to do so
+	relogin func() error
+	// flow is the login flow whose headers to send, if any
+	flow *loginFlow
+
+	mu   sync.RWMutex
+	dead bool
+}
+
+// loggedIn fetches the check URL with the crawl's headers and cookies and looks for signs of being logged out
+func (w *sessionWatchdog) loggedIn() (bool, error) {
+	req, err := http.NewRequest("GET", w.checkURL, nil)
+	if err != nil {
+		return false, err
+	}
+	for header, value := range headers {
+		req.Header.Set(header, value)
+	}
+	for header, value := range headersForHost(req.URL.Hostname()) {
+		req.Header.Set(header, value)
+	}
+	if w.flow != nil {
+		w.flow.mu.RLock()
+		for header, value := range w.flow.current {
+			req.Header.Set(header, value)
+		}
+		w.flow.mu.RUnlock()
+	}
+	for _, cookie := range w.collector.Cookies(w.checkURL) {
+		req.AddCookie(cookie)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return false, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	return !w.loggedOut.Match(body), nil
+}
+
+// run checks the session every interval until stop is closed
+func (w *sessionWatchdog) run(stop chan struct{}) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		ok, err := w.loggedIn()
+		if err != nil {
+			log.Println("Error checking session:", err)
+			continue
+		}
+		if ok {
+			continue
+		}
+
+		// pause the crawl while the session is restored
+		w.mu.Lock()
+		log.Println("[session] logged out, pausing the crawl")
+		if w.relogin != nil {
+			if err := w.relogin(); err != nil {
+				log.Println("[session] error logging in again:", err)
+			}
+			ok, _ = w.loggedIn()
+		}
+		if ok {
+			log.Println("[session] logged in again, resuming the crawl")
+		} else {
+			log.Println("[session] could not restore the session, stopping the crawl")
+			w.dead = true
+		}
+		w.mu.Unlock()
+		if w.dead {
+			return
+		}
+	}
+}
+
+// wait holds a request back while the session is being restored, and aborts it if that failed
+func (w *sessionWatchdog) wait(r *colly.Request) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.dead {
+		abortRequest(r)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gocolly/colly/v2"
+)
+
+var (
+	tagPattern  = regexp.MustCompile(`(?s)<script.*?</script>|<style.*?</style>|<[^>]*>`)
+	wordPattern = regexp.MustCompile(`\w+`)
+)
+
+// simhashes tracks the fingerprints of crawled pages to spot near-duplicates of them
+type simhashes struct {
+	maxDistance int
+	mu          sync.Mutex
+	seen        []uint64
+	// duplicates holds the responses found to be near-duplicates, until they are scraped
+	duplicates sync.Map
+}
+
+// check fingerprints a response and remembers it as a near-duplicate if an already crawled page is
+// within maxDistance bits of it. Otherwise its fingerprint is kept for comparing the next pages.
+func (s *simhashes) check(r *colly.Response) {
+	hash := simhash(string(r.Body))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, seen := range s.seen {
+		if bits.OnesCount64(seen^hash) <= s.maxDistance {
+			s.duplicates.Store(r, true)
+			return
+		}
+	}
+	s.seen = append(s.seen, hash)
+}
+
+// isDuplicate reports whether a response was found to be a near-duplicate
+func (s *simhashes) isDuplicate(r *colly.Response) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := s.duplicates.Load(r)
+	return ok
+}
+
+// forget drops a response once it has been scraped
+func (s *simhashes) forget(r *colly.Response) {
+	s.duplicates.Delete(r)
+}
+
+// simhash computes a 64 bit fingerprint of the words of an HTML page, which differs only by a few
+// bits between pages of similar content
+func simhash(body string) uint64 {
+	var weights [64]int
+	text := tagPattern.ReplaceAllString(body, " ")
+	for _, word := range wordPattern.FindAllString(strings.ToLower(text), -1) {
+		h := fnv.New64a()
+		h.Write([]byte(word))
+		sum := h.Sum64()
+		for i := range weights {
+			if sum&(1<<uint(i)) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+	var hash uint64
+	for i, w := range weights {
+		if w > 0 {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
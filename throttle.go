@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// bandwidthLimiter slows down the reading of response bodies so that, all requests together,
+// no more than rate bytes per second are downloaded
+type bandwidthLimiter struct {
+	rate float64
+	mu   sync.Mutex
+	// free is when the bytes read so far will have been paid for
+	free time.Time
+}
+
+func newBandwidthLimiter(rate float64) *bandwidthLimiter {
+	return &bandwidthLimiter{rate: rate}
+}
+
+// wrap returns a RoundTripper whose response bodies are read at the pace of the limiter
+func (l *bandwidthLimiter) wrap(next http.RoundTripper) http.RoundTripper {
+	return &throttledTransport{next: next, limiter: l}
+}
+
+// parseBandwidth parses a rate such as 2MB/s, 500KB/s or 100000 (bytes per second)
+func parseBandwidth(s string) (float64, error) {
+	trimmed := strings.TrimSpace(s)
+	if strings.HasSuffix(strings.ToLower(trimmed), "/s") {
+		trimmed = trimmed[:len(trimmed)-2]
+	}
+	rate, err := parseSize(trimmed)
+	if err != nil {
+		return 0, fmt.Errorf("bad bandwidth %q", s)
+	}
+	return rate, nil
+}
+
+// parseSize parses a size such as 2MB, 1.5GB, 500KB or 100000 (bytes)
+func parseSize(s string) (float64, error) {
+	number := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := 1.0
+	for _, unit := range []struct {
+		suffix string
+		bytes  float64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSuffix(number, unit.suffix), unit.bytes
+			break
+		}
+	}
+	size, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return size * multiplier, nil
+}
+
+// wait blocks until n more bytes may be read
+func (l *bandwidthLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.free.Before(now) {
+		l.free = now
+	}
+	l.free = l.free.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	delay := l.free.Sub(now)
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// throttledTransport is a RoundTripper sharing a bandwidthLimiter with others
+type throttledTransport struct {
+	next    http.RoundTripper
+	limiter *bandwidthLimiter
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	resp.Body = throttledBody{resp.Body, t.limiter}
+	return resp, nil
+}
+
+// throttledBody is a response body read at the pace of its limiter
+type throttledBody struct {
+	io.ReadCloser
+	limiter *bandwidthLimiter
+}
+
+func (b throttledBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.limiter.wait(n)
+	}
+	return n, err
+}
+
+// memoryGuard tells when the heap is over a limit, for -max-memory. The frontier spills the
+// links found meanwhile, and the requests already queued wait: they hold on to little, while
+// the pages being parsed are freed as they complete.
+type memoryGuard struct {
+	limit uint64
+	// over is 1 while the heap is over the limit
+	over int32
+}
+
+// newMemoryGuard starts checking the heap size twice a second
+func newMemoryGuard(limit uint64) *memoryGuard {
+	g := &memoryGuard{limit: limit}
+	go func() {
+		var stats runtime.MemStats
+		warned := false
+		for range time.Tick(500 * time.Millisecond) {
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc <= g.limit {
+				atomic.StoreInt32(&g.over, 0)
+				warned = false
+				continue
+			}
+			atomic.StoreInt32(&g.over, 1)
+			if !warned {
+				log.Println("[memory] heap over -max-memory, holding back new requests")
+				warned = true
+				// some of the heap may be garbage not collected yet. Collecting once is enough,
+				// the pages being parsed are freed as they complete.
+				runtime.GC()
+			}
+		}
+	}()
+	return g
+}
+
+// wait blocks a request until the heap is back under the limit
+func (g *memoryGuard) wait(r *colly.Request) {
+	for atomic.LoadInt32(&g.over) == 1 {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// rateControl limits the requests in flight and spaces them out, with settings that can be
+// changed while the crawl runs by editing the -control-file. The collector's own parallelism
+// (-t) stays the upper bound.
+type rateControl struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	delay    time.Duration
+	active   int
+	filename string
+	modified time.Time
+}
+
+// rateSettings is the content of the control file
+type rateSettings struct {
+	Parallelism int `json:"parallelism"`
+	DelayMs     int `json:"delay_ms"`
+}
+
+// newRateControl reads the control file, then checks it for changes every two seconds
+func newRateControl(filename string) (*rateControl, error) {
+	rc := &rateControl{filename: filename}
+	rc.cond = sync.NewCond(&rc.mu)
+	if err := rc.reload(); err != nil {
+		return nil, err
+	}
+	go func() {
+		for range time.Tick(2 * time.Second) {
+			if err := rc.reload(); err != nil {
+				log.Println("Error reloading control file:", err)
+			}
+		}
+	}()
+	return rc, nil
+}
+
+// reload applies the control file if it changed since it was last read
+func (rc *rateControl) reload() error {
+	info, err := os.Stat(rc.filename)
+	if err != nil {
+		return err
+	}
+	rc.mu.Lock()
+	unchanged := info.ModTime().Equal(rc.modified)
+	rc.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+	data, err := os.ReadFile(rc.filename)
+	if err != nil {
+		return err
+	}
+	var settings rateSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+	rc.mu.Lock()
+	changed := !rc.modified.IsZero()
+	rc.modified = info.ModTime()
+	rc.limit = settings.Parallelism
+	rc.delay = time.Duration(settings.DelayMs) * time.Millisecond
+	rc.mu.Unlock()
+	rc.cond.Broadcast()
+	if changed {
+		log.Printf("[control] parallelism %d, delay %v\n", settings.Parallelism, rc.delay)
+	}
+	return nil
+}
+
+// wrap returns a RoundTripper sending requests through next at the controlled rate
+func (rc *rateControl) wrap(next http.RoundTripper) http.RoundTripper {
+	return &controlledTransport{next: next, control: rc}
+}
+
+// controlledTransport is a RoundTripper sharing a rateControl with others
+type controlledTransport struct {
+	next    http.RoundTripper
+	control *rateControl
+}
+
+func (t *controlledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rc := t.control
+	rc.mu.Lock()
+	// a parallelism of 0 or less leaves it to -t
+	for rc.limit > 0 && rc.active >= rc.limit {
+		rc.cond.Wait()
+	}
+	rc.active++
+	delay := rc.delay
+	rc.mu.Unlock()
+
+	time.Sleep(delay)
+	resp, err := t.next.RoundTrip(req)
+
+	rc.mu.Lock()
+	rc.active--
+	rc.mu.Unlock()
+	rc.cond.Signal()
+	return resp, err
+}
+
+// crawlDelays spaces out the requests to every host by the Crawl-delay of its robots.txt, for
+// -respect-robots. It only ever slows the crawl down: the other rate settings still apply.
+type crawlDelays struct {
+	mu    sync.Mutex
+	hosts map[string]*hostDelay
+}
+
+// hostDelay is the Crawl-delay of a host, read once, and when its next request may be sent
+type hostDelay struct {
+	once  sync.Once
+	delay time.Duration
+	mu    sync.Mutex
+	next  time.Time
+}
+
+func newCrawlDelays() *crawlDelays {
+	return &crawlDelays{hosts: make(map[string]*hostDelay)}
+}
+
+// wrap returns a RoundTripper sending requests through next no faster than robots.txt asks.
+// Until its fetch and cookies are set, robots.txt is fetched through next without cookies.
+func (d *crawlDelays) wrap(next http.RoundTripper) *crawlDelayTransport {
+	return &crawlDelayTransport{next: next, delays: d, fetch: next}
+}
+
+// host returns the Crawl-delay of the host a request is for, fetching its robots.txt first
+// if needed, through fetch with the request's headers and the cookies for robots.txt
+func (d *crawlDelays) host(req *http.Request, fetch http.RoundTripper, cookies func(string) []*http.Cookie) *hostDelay {
+	origin := req.URL.Scheme + "://" + req.URL.Host
+	d.mu.Lock()
+	h, ok := d.hosts[origin]
+	if !ok {
+		h = &hostDelay{}
+		d.hosts[origin] = h
+	}
+	d.mu.Unlock()
+
+	h.once.Do(func() {
+		robots, err := http.NewRequest("GET", origin+"/robots.txt", nil)
+		if err != nil {
+			return
+		}
+		robots.Host = req.Host
+		robots.Header = req.Header.Clone()
+		// the request's cookies are for its own path
+		robots.Header.Del("Cookie")
+		if cookies != nil {
+			for _, cookie := range cookies(robots.URL.String()) {
+				robots.AddCookie(cookie)
+			}
+		}
+		resp, err := fetch.RoundTrip(robots)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 512*1024))
+		if err != nil {
+			return
+		}
+		h.delay = robotsCrawlDelay(string(body), req.Header.Get("User-Agent"))
+		if h.delay > 0 {
+			log.Printf("[robots] crawl delay of %v for %s\n", h.delay, origin)
+		}
+	})
+	return h
+}
+
+// crawlDelayTransport is a RoundTripper sharing crawlDelays with others
+type crawlDelayTransport struct {
+	next   http.RoundTripper
+	delays *crawlDelays
+	// fetch is the RoundTripper robots.txt is fetched through, and cookies returns the cookies
+	// of a URL. Set them to the collector's whole chain and jar to fetch robots.txt like a page.
+	fetch   http.RoundTripper
+	cookies func(string) []*http.Cookie
+}
+
+func (t *crawlDelayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// colly fetches robots.txt itself to check the Disallow rules
+	if req.URL.Path == "/robots.txt" {
+		return t.next.RoundTrip(req)
+	}
+	h := t.delays.host(req, t.fetch, t.cookies)
+	if h.delay > 0 {
+		h.mu.Lock()
+		start := time.Now()
+		if h.next.After(start) {
+			start = h.next
+		}
+		h.next = start.Add(h.delay)
+		h.mu.Unlock()
+		time.Sleep(time.Until(start))
+	}
+	return t.next.RoundTrip(req)
+}
+
+// robotsCrawlDelay returns the Crawl-delay robots.txt sets for the product token of a user
+// agent, in the group naming it, or else in the * group
+func robotsCrawlDelay(robots string, userAgent string) time.Duration {
+	token := strings.ToLower(productToken(userAgent))
+	delay, matched := time.Duration(0), -1
+	// how well the user agents of the current group match, 1 for the token and 0 for *, and
+	// the group's delay
+	groupMatch, groupDelay := -1, time.Duration(0)
+	endGroup := func() {
+		if groupMatch > matched {
+			delay, matched = groupDelay, groupMatch
+		}
+		groupMatch, groupDelay = -1, 0
+	}
+	inRules := false
+	for _, line := range strings.Split(robots, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := strings.ToLower(strings.TrimSpace(parts[0])), strings.ToLower(strings.TrimSpace(parts[1]))
+		if key != "user-agent" {
+			inRules = true
+			if seconds, err := strconv.ParseFloat(value, 64); key == "crawl-delay" && err == nil && seconds > 0 {
+				groupDelay = time.Duration(seconds * float64(time.Second))
+			}
+			continue
+		}
+		// a user-agent line after rules starts a new group
+		if inRules {
+			endGroup()
+			inRules = false
+		}
+		// some sites name a version too, as in hakrawler/2.0
+		value = strings.TrimSpace(strings.SplitN(value, "/", 2)[0])
+		switch {
+		case value == "*" && groupMatch < 0:
+			groupMatch = 0
+		case value != "" && value == token:
+			groupMatch = 1
+		}
+	}
+	endGroup()
+	return delay
+}
+
+// productToken returns the name a crawler goes by in robots.txt: the product its user agent
+// says it is compatible with, as hakrawler in "Mozilla/5.0 (compatible; hakrawler/2.0)", or
+// else its first product
+func productToken(userAgent string) string {
+	if i := strings.Index(strings.ToLower(userAgent), "(compatible;"); i >= 0 {
+		comment := userAgent[i+len("(compatible;"):]
+		if end := strings.IndexAny(comment, ";)"); end >= 0 {
+			comment = comment[:end]
+		}
+		if name := strings.TrimSpace(strings.SplitN(comment, "/", 2)[0]); name != "" {
+			return name
+		}
+	}
+	fields := strings.Fields(userAgent)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.SplitN(fields[0], "/", 2)[0]
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRobotsCrawlDelay(t *testing.T) {
+	const userAgent = "Mozilla/5.0 (compatible; hakrawler/2.0)"
+	tests := []struct {
+		name   string
+		robots string
+		delay  time.Duration
+	}{
+		{"none", "User-agent: *\nDisallow: /admin\n", 0},
+		{"any user agent", "User-agent: *\nCrawl-delay: 2\n", 2 * time.Second},
+		{"fraction", "User-agent: *\nCrawl-delay: 0.5\n", 500 * time.Millisecond},
+		{"own group", "User-agent: *\nCrawl-delay: 10\n\nUser-agent: Hakrawler\nCrawl-delay: 1\n", time.Second},
+		{"other group", "User-agent: googlebot\nCrawl-delay: 5\n", 0},
+		{"product token", "User-agent: mozilla\nCrawl-delay: 3\n\nUser-agent: hakrawler/2\nCrawl-delay: 4\n", 4 * time.Second},
+		{"part of the token", "User-agent: hak\nCrawl-delay: 3\n\nUser-agent: compatible\nCrawl-delay: 4\n", 0},
+		{"longer token", "User-agent: hakrawler-plus\nCrawl-delay: 3\n", 0},
+		{"shared group", "User-agent: googlebot\nUser-agent: hakrawler\nCrawl-delay: 6\n", 6 * time.Second},
+		{"comments and spacing", "user-agent:*   # everyone\n  crawl-delay :  7 # seconds\n", 7 * time.Second},
+		{"invalid delay", "User-agent: *\nCrawl-delay: soon\n", 0},
+		{"negative delay", "User-agent: *\nCrawl-delay: -1\n", 0},
+	}
+	for _, test := range tests {
+		if delay := robotsCrawlDelay(test.robots, userAgent); delay != test.delay {
+			t.Errorf("%s: robotsCrawlDelay = %v, want %v", test.name, delay, test.delay)
+		}
+	}
+}
+
+func TestProductToken(t *testing.T) {
+	tests := []struct {
+		userAgent, want string
+	}{
+		{"Mozilla/5.0 (compatible; hakrawler/2.0)", "hakrawler"},
+		{"Mozilla/5.0 (Compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "Googlebot"},
+		{"hakrawler/2.0", "hakrawler"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/104.0.0.0 Safari/537.36", "Mozilla"},
+		{"hakrawler", "hakrawler"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := productToken(test.userAgent); got != test.want {
+			t.Errorf("productToken(%q) = %q, want %q", test.userAgent, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// timingHeader carries the ID of a collector request down to the RoundTrippers, the only way
+// colly lets callbacks pass anything to them. It is taken off before the request is sent.
+const timingHeader = "X-Hakrawler-Timing"
+
+// timingKey is the context key the ID of a timed request is kept under
+type timingKey struct{}
+
+// requestTiming is how long a request took, including its redirects: until the first byte of
+// the last response, and until its body was read, in milliseconds
+type requestTiming struct {
+	start    time.Time
+	ttfb     float64
+	duration float64
+	// done is set once the body of the last response has been read
+	done bool
+}
+
+// timingRecorder measures the requests of collectors, for -timing. Timings are kept by
+// request ID from the first request sent until the collector is done with the response.
+type timingRecorder struct {
+	mu      sync.Mutex
+	timings map[uint32]*requestTiming
+}
+
+// Timings of requests, set with -timing, or nil
+var requestTimings *timingRecorder
+
+func newTimingRecorder() *timingRecorder {
+	return &timingRecorder{timings: make(map[uint32]*requestTiming)}
+}
+
+// track registers the callbacks tagging the requests of a collector with their ID, and
+// forgetting their timing once the collector is done with them. It is to be called after every
+// other callback is registered, so that requests aborted by them are not tagged, and their
+// results are given their timing before it is forgotten.
+func (t *timingRecorder) track(c *colly.Collector) {
+	c.OnRequest(func(r *colly.Request) {
+		r.Headers.Set(timingHeader, strconv.FormatUint(uint64(r.ID), 10))
+	})
+	forget := func(r *colly.Request) {
+		t.mu.Lock()
+		delete(t.timings, r.ID)
+		t.mu.Unlock()
+	}
+	c.OnScraped(func(r *colly.Response) { forget(r.Request) })
+	c.OnError(func(r *colly.Response, err error) { forget(r.Request) })
+}
+
+// get returns the timing of a request, once its response has been read
+func (t *timingRecorder) get(id uint32) (requestTiming, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timing, ok := t.timings[id]
+	if !ok || !timing.done {
+		return requestTiming{}, false
+	}
+	return *timing, true
+}
+
+// wrap returns a RoundTripper timing the tagged requests sent through next, which is to be
+// as close to the network as possible
+func (t *timingRecorder) wrap(next http.RoundTripper) http.RoundTripper {
+	return &timingTransport{next: next, recorder: t}
+}
+
+// untag returns a RoundTripper taking the ID tag off requests before they are sent through
+// next, and passing it on in their context. It is to wrap every other RoundTripper, so that
+// none of them sees the tag.
+func (t *timingRecorder) untag(next http.RoundTripper) http.RoundTripper {
+	return &untagTransport{next: next}
+}
+
+// untagTransport is a RoundTripper moving the timingHeader of requests to their context
+type untagTransport struct {
+	next http.RoundTripper
+}
+
+func (t *untagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id, err := strconv.ParseUint(req.Header.Get(timingHeader), 10, 32)
+	if err != nil {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(context.WithValue(req.Context(), timingKey{}, uint32(id)))
+	req.Header.Del(timingHeader)
+	return t.next.RoundTrip(req)
+}
+
+// timingTransport is a RoundTripper recording timings in a timingRecorder
+type timingTransport struct {
+	next     http.RoundTripper
+	recorder *timingRecorder
+}
+
+func (t *timingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id, ok := req.Context().Value(timingKey{}).(uint32)
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+	t.recorder.mu.Lock()
+	timing, exists := t.recorder.timings[id]
+	switch {
+	case !exists:
+		timing = &requestTiming{start: time.Now()}
+		t.recorder.timings[id] = timing
+	case req.Response != nil:
+		// the next hop of a redirect, timed from the first request
+		timing.done = false
+	case timing.done:
+		// requests made with a copy of the request's headers once it is over, e.g. by -reflect,
+		// are not timed
+		t.recorder.mu.Unlock()
+		return t.next.RoundTrip(req)
+	}
+	t.recorder.mu.Unlock()
+
+	var firstByte time.Time
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { firstByte = time.Now() },
+	}
+	resp, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		return resp, err
+	}
+	if firstByte.IsZero() {
+		firstByte = time.Now()
+	}
+	// the timing is complete once the body has been read and closed
+	resp.Body = &timedBody{ReadCloser: resp.Body, done: func() {
+		t.recorder.mu.Lock()
+		timing.ttfb = milliseconds(firstByte.Sub(timing.start))
+		timing.duration = milliseconds(time.Since(timing.start))
+		timing.done = true
+		t.recorder.mu.Unlock()
+	}}
+	return resp, nil
+}
+
+// milliseconds converts a duration to milliseconds, to a tenth of one
+func milliseconds(d time.Duration) float64 {
+	return float64(d/(100*time.Microsecond)) / 10
+}
+
+// timedBody calls done when a response body is first closed
+type timedBody struct {
+	io.ReadCloser
+	done func()
+	once sync.Once
+}
+
+func (b *timedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// otlpExporter sends a span per request to an OpenTelemetry collector, over OTLP/HTTP with
+// JSON encoding. Spans are sent in batches every few seconds.
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+	mu       sync.Mutex
+	spans    []otlpSpan
+}
+
+// otlpSpan is a span in the OTLP JSON encoding
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	} `json:"value"`
+}
+
+func stringAttribute(key string, value string) otlpAttribute {
+	a := otlpAttribute{Key: key}
+	a.Value.StringValue = &value
+	return a
+}
+
+func intAttribute(key string, value int64) otlpAttribute {
+	a := otlpAttribute{Key: key}
+	s := strconv.FormatInt(value, 10)
+	a.Value.IntValue = &s
+	return a
+}
+
+// newOTLPExporter exports spans to the collector at endpoint, e.g. http://localhost:4318
+func newOTLPExporter(endpoint string) *otlpExporter {
+	e := &otlpExporter{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	go func() {
+		for range time.Tick(5 * time.Second) {
+			e.flush()
+		}
+	}()
+	return e
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// record queues a span for a request
+func (e *otlpExporter) record(req *http.Request, start time.Time, resp *http.Response, err error) {
+	span := otlpSpan{
+		TraceID:           randomHex(16),
+		SpanID:            randomHex(8),
+		Name:              req.Method + " " + req.URL.Host,
+		Kind:              3, // client
+		StartTimeUnixNano: strconv.FormatInt(start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes: []otlpAttribute{
+			stringAttribute("http.request.method", req.Method),
+			stringAttribute("url.full", req.URL.String()),
+			stringAttribute("server.address", req.URL.Hostname()),
+		},
+	}
+	if err != nil {
+		span.Status.Code = 2 // error
+		span.Status.Message = err.Error()
+	} else {
+		span.Attributes = append(span.Attributes, intAttribute("http.response.status_code", int64(resp.StatusCode)))
+		if resp.StatusCode >= 500 {
+			span.Status.Code = 2
+		}
+	}
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	e.mu.Unlock()
+}
+
+// flush sends the queued spans
+func (e *otlpExporter) flush() {
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+	if spans == nil {
+		return
+	}
+
+	service := stringAttribute("service.name", "hakrawler")
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": []otlpAttribute{service}},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "hakrawler"},
+				"spans": spans,
+			}},
+		}},
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Println("Error exporting spans:", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Println("Error exporting spans: collector answered", resp.Status)
+	}
+}
+
+// wrap returns a RoundTripper recording a span for every request sent through next
+func (e *otlpExporter) wrap(next http.RoundTripper) http.RoundTripper {
+	return &tracingTransport{next: next, exporter: e}
+}
+
+// tracingTransport is a RoundTripper recording spans with an otlpExporter
+type tracingTransport struct {
+	next     http.RoundTripper
+	exporter *otlpExporter
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	t.exporter.record(req, start, resp, err)
+	return resp, err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxDumpedBody is how much of each response body gets written to the traffic dump
+const maxDumpedBody = 4096
+
+// trafficRecord is one request and its response, as written to the traffic dump
+type trafficRecord struct {
+	Time     time.Time         `json:"time"`
+	Request  trafficRequest    `json:"request"`
+	Response *trafficResponse  `json:"response,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Timing   map[string]string `json:"timing"`
+}
+
+type trafficRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+}
+
+type trafficResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+	// Truncated is set when only the start of the body was kept
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// trafficDumper writes every request going through it, and its response, as JSON lines
+type trafficDumper struct {
+	next http.RoundTripper
+	mu   sync.Mutex
+	out  *json.Encoder
+}
+
+func newTrafficDumper(next http.RoundTripper, w io.Writer) *trafficDumper {
+	return &trafficDumper{next: next, out: json.NewEncoder(w)}
+}
+
+func (d *trafficDumper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	record := &trafficRecord{
+		Time:    start,
+		Request: trafficRequest{Method: req.Method, URL: req.URL.String(), Headers: req.Header.Clone()},
+		Timing:  make(map[string]string),
+	}
+	resp, err := d.next.RoundTrip(req)
+	record.Timing["headers"] = time.Since(start).String()
+	if err != nil {
+		record.Error = err.Error()
+		d.write(record)
+		return resp, err
+	}
+	record.Response = &trafficResponse{Status: resp.StatusCode, Headers: resp.Header.Clone()}
+	// the record is written once the body has been read and closed
+	resp.Body = &dumpedBody{ReadCloser: resp.Body, dumper: d, record: record, start: start}
+	return resp, nil
+}
+
+func (d *trafficDumper) write(record *trafficRecord) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.out.Encode(record)
+}
+
+// dumpedBody keeps the start of a response body for the traffic dump as it is read
+type dumpedBody struct {
+	io.ReadCloser
+	dumper *trafficDumper
+	record *trafficRecord
+	start  time.Time
+	body   []byte
+	read   int
+	once   sync.Once
+}
+
+func (b *dumpedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := maxDumpedBody - len(b.body); room > 0 {
+		if room > n {
+			room = n
+		}
+		b.body = append(b.body, p[:room]...)
+	}
+	b.read += n
+	return n, err
+}
+
+func (b *dumpedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.record.Response.Body = string(b.body)
+		b.record.Response.Truncated = b.read > len(b.body)
+		b.record.Timing["total"] = time.Since(b.start).String()
+		b.dumper.write(b.record)
+	})
+	return err
+}